@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+const (
+	// The path meaning stdin or stdout
+	stdPath = "-"
+	// Permissions used for files written by the tool
+	outputPermissions fs.FileMode = 0o600
+)
+
+var (
+	// Error returned when the passwords typed at the prompt don't match
+	errPasswordMismatch = errors.New("passwords do not match")
+	// Error returned when a password is empty
+	errEmptyPassword = errors.New("empty password")
+)
+
+// Encrypts a file with a password or a key file
+func runEncrypt(env *environment, args []string) (err error) {
+	flags := newFlagSet("encrypt", env)
+	in := flags.String("in", stdPath, "input `file` (- for stdin)")
+	out := flags.String("out", stdPath, "output `file` (- for stdout)")
+	keyPath := flags.String("key", "", "encrypt with the key stored in this key `file` instead of a password")
+	passwordFile := flags.String("password-file", "", "read the password (or key file passphrase) from this `file` instead of prompting")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	plaintext, err := readInput(env, *in)
+	if err != nil {
+		return
+	}
+	var (
+		header = containerHeader{version: containerVersion}
+		key    crypto.AESKey
+	)
+	if *keyPath != "" {
+		header.mode = modeKeyFile
+		if key, err = loadKey(env, *keyPath, *passwordFile); err != nil {
+			return
+		}
+	} else {
+		header.mode = modePassword
+		header.time, header.memory, header.threads = crypto.DefaultTime, crypto.DefaultMemory, crypto.DefaultParallelism
+		header.salt = make([]byte, containerSaltSize)
+		if _, err = io.ReadFull(rand.Reader, header.salt); err != nil {
+			return
+		}
+		var password string
+		if password, err = readPassword(env, *passwordFile, "Password: ", true); err != nil {
+			return
+		}
+		key = header.deriveKey(password)
+	}
+	encodedHeader := header.append(nil)
+	ciphertext, err := crypto.EncryptWithData(key, plaintext, encodedHeader)
+	if err != nil {
+		return
+	}
+	return writeOutput(env, *out, append(encodedHeader, ciphertext...))
+}
+
+// Decrypts a file with a password or a key file
+func runDecrypt(env *environment, args []string) (err error) {
+	flags := newFlagSet("decrypt", env)
+	in := flags.String("in", stdPath, "input `file` (- for stdin)")
+	out := flags.String("out", stdPath, "output `file` (- for stdout)")
+	keyPath := flags.String("key", "", "decrypt with the key stored in this key `file`")
+	passwordFile := flags.String("password-file", "", "read the password (or key file passphrase) from this `file` instead of prompting")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	data, err := readInput(env, *in)
+	if err != nil {
+		return
+	}
+	header, encodedHeader, ciphertext, err := parseContainer(data)
+	if err != nil {
+		return
+	}
+	var key crypto.AESKey
+	switch header.mode {
+	case modeKeyFile:
+		if *keyPath == "" {
+			return usageError{errors.New("the input was encrypted with a key file: use -key")}
+		}
+		if key, err = loadKey(env, *keyPath, *passwordFile); err != nil {
+			return
+		}
+	case modePassword:
+		if *keyPath != "" {
+			return usageError{errors.New("the input was encrypted with a password: -key cannot be used")}
+		}
+		var password string
+		if password, err = readPassword(env, *passwordFile, "Password: ", false); err != nil {
+			return
+		}
+		key = header.deriveKey(password)
+	}
+	plaintext, err := crypto.DecryptWithData(key, ciphertext, encodedHeader)
+	if err != nil {
+		return authError{fmt.Errorf("decryption failed (wrong password or key, or the data was tampered with): %w", err)}
+	}
+	return writeOutput(env, *out, plaintext)
+}
+
+// Generates a new key and stores it in a key file
+func runKeygen(env *environment, args []string) (err error) {
+	flags := newFlagSet("keygen", env)
+	out := flags.String("out", "", "the key `file` to create (required)")
+	passwordFile := flags.String("password-file", "", "read the key file passphrase from this `file` instead of prompting")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	if *out == "" || *out == stdPath {
+		return usageError{errors.New("-out must be set to the path of the key file")}
+	}
+	passphrase, err := readPassword(env, *passwordFile, "Key file passphrase: ", true)
+	if err != nil {
+		return
+	}
+	key, err := crypto.NewKey()
+	if err != nil {
+		return
+	}
+	if err = crypto.SaveKeyFile(*out, key, passphrase); err != nil {
+		return ioError{err}
+	}
+	return
+}
+
+// Derives a key from a password and prints it in hex
+func runDerive(env *environment, args []string) (err error) {
+	flags := newFlagSet("derive", env)
+	saltHex := flags.String("salt", "", "the salt in `hex`")
+	saltFile := flags.String("salt-file", "", "read the salt from this `file`, creating it with a random salt if missing")
+	time := flags.Uint("time", uint(crypto.DefaultTime), "the Argon2id time parameter")
+	memory := flags.Uint("memory", uint(crypto.DefaultMemory), "the Argon2id memory parameter in `KiB`")
+	threads := flags.Uint("threads", uint(crypto.DefaultParallelism), "the Argon2id threads parameter")
+	passwordFile := flags.String("password-file", "", "read the password from this `file` instead of prompting")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	if *time == 0 || uint64(*time) > math.MaxUint32 || *memory == 0 || uint64(*memory) > math.MaxUint32 || *threads == 0 || *threads > math.MaxUint8 {
+		return usageError{errors.New("-time and -memory must be in [1, 2^32), -threads in [1, 255]")}
+	}
+	if (*saltHex == "") == (*saltFile == "") {
+		return usageError{errors.New("exactly one of -salt and -salt-file is required")}
+	}
+	var salt []byte
+	if *saltHex != "" {
+		if salt, err = hex.DecodeString(*saltHex); err != nil {
+			return usageError{fmt.Errorf("invalid -salt: %w", err)}
+		}
+		if len(salt) < crypto.MinKDFSaltSize {
+			return usageError{fmt.Errorf("invalid -salt: %w", crypto.ErrShortSalt)}
+		}
+	} else if salt, err = crypto.LoadOrCreateSalt(*saltFile); err != nil {
+		return ioError{err}
+	}
+	password, err := readPassword(env, *passwordFile, "Password: ", false)
+	if err != nil {
+		return
+	}
+	key, err := crypto.DeriveSecureKeyStrict(password, salt, uint32(*time), uint32(*memory), uint8(*threads))
+	if err != nil {
+		return
+	}
+	if _, err = fmt.Fprintf(env.stdout, "%x\n", key); err != nil {
+		return ioError{err}
+	}
+	return
+}
+
+// Prints a random value
+func runRandom(env *environment, args []string) (err error) {
+	flags := newFlagSet("random", env)
+	length := flags.Int("length", 32, "the `length` of the hex string")
+	asUint64 := flags.Bool("uint64", false, "print a random uint64 instead of a hex string")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	if *length < 0 {
+		return usageError{errors.New("-length must not be negative")}
+	}
+	var value any
+	if *asUint64 {
+		value, err = crypto.ReadRandomUint64()
+	} else {
+		value, err = crypto.ReadRandomHex(*length)
+	}
+	if err != nil {
+		return
+	}
+	if _, err = fmt.Fprintln(env.stdout, value); err != nil {
+		return ioError{err}
+	}
+	return
+}
+
+// Prints the header of an encrypted file
+func runInspect(env *environment, args []string) (err error) {
+	flags := newFlagSet("inspect", env)
+	in := flags.String("in", stdPath, "input `file` (- for stdin)")
+	if err = parseFlags(flags, args); err != nil {
+		return
+	}
+	data, err := readInput(env, *in)
+	if err != nil {
+		return
+	}
+	header, _, ciphertext, err := parseContainer(data)
+	if err != nil {
+		return
+	}
+	output := &strings.Builder{}
+	fmt.Fprintf(output, "version:  %d\n", header.version)
+	fmt.Fprintf(output, "mode:     %s\n", header.mode)
+	if header.mode == modePassword {
+		fmt.Fprintf(output, "kdf:      argon2id t=%d m=%d p=%d\n", header.time, header.memory, header.threads)
+		fmt.Fprintf(output, "salt:     %x\n", header.salt)
+	}
+	if len(ciphertext) >= crypto.NonceSize {
+		fmt.Fprintf(output, "nonce:    %x\n", ciphertext[:crypto.NonceSize])
+	}
+	fmt.Fprintf(output, "payload:  %d bytes\n", len(ciphertext))
+	if _, err = io.WriteString(env.stdout, output.String()); err != nil {
+		return ioError{err}
+	}
+	return
+}
+
+// Returns a new flag set for a command
+func newFlagSet(name string, env *environment) *flag.FlagSet {
+	flags := flag.NewFlagSet("gosymcrypto "+name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	return flags
+}
+
+// Parses the flags of a command, rejecting positional arguments
+func parseFlags(flags *flag.FlagSet, args []string) (err error) {
+	if err = flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected argument %q", flags.Arg(0))}
+	}
+	return
+}
+
+// Reads all the data from a file or stdin
+func readInput(env *environment, path string) (data []byte, err error) {
+	if path == stdPath {
+		data, err = io.ReadAll(env.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		err = ioError{err}
+	}
+	return
+}
+
+// Writes data to a file or stdout
+func writeOutput(env *environment, path string, data []byte) (err error) {
+	if path == stdPath {
+		_, err = env.stdout.Write(data)
+	} else {
+		err = helpers.WriteFileAtomic(path, data, outputPermissions)
+	}
+	if err != nil {
+		err = ioError{err}
+	}
+	return
+}
+
+// Loads a key from a key file, asking for its passphrase
+func loadKey(env *environment, path string, passwordFile string) (key crypto.AESKey, err error) {
+	passphrase, err := readPassword(env, passwordFile, "Key file passphrase: ", false)
+	if err != nil {
+		return
+	}
+	key, err = crypto.LoadKeyFile(path, passphrase)
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, crypto.ErrKeyFilePassphrase), errors.Is(err, crypto.ErrKeyFileFormat), errors.Is(err, crypto.ErrKeyFileVersion):
+		// Like a wrong key or a tampered container
+		err = authError{err}
+	case errors.Is(err, crypto.ErrKeyFilePermissions), errors.As(err, &pathErr):
+		err = ioError{err}
+	}
+	return
+}
+
+// Reads a password from passwordFile, or prompts for it without echo
+// if passwordFile is empty. If confirm is set, the prompt asks twice.
+func readPassword(env *environment, passwordFile string, prompt string, confirm bool) (password string, err error) {
+	if passwordFile != "" {
+		var data []byte
+		if data, err = os.ReadFile(passwordFile); err != nil {
+			err = ioError{err}
+			return
+		}
+		// Only strip the line ending, the rest is part of the password
+		data = bytes.TrimSuffix(data, []byte("\n"))
+		data = bytes.TrimSuffix(data, []byte("\r"))
+		password = string(data)
+	} else {
+		if password, err = promptPassword(prompt); err != nil {
+			err = ioError{err}
+			return
+		}
+		if confirm {
+			var again string
+			if again, err = promptPassword("Repeat " + strings.ToLower(prompt[:1]) + prompt[1:]); err != nil {
+				err = ioError{err}
+				return
+			}
+			if again != password {
+				err = usageError{errPasswordMismatch}
+				return
+			}
+		}
+	}
+	if password == "" {
+		err = usageError{errEmptyPassword}
+	}
+	return
+}
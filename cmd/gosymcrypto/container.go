@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+const (
+	// The current version of the encrypted file format
+	containerVersion uint8 = 1
+	// The size of the salt generated for password-encrypted files
+	containerSaltSize = 32
+	// The largest Argon2id time accepted in an encrypted file
+	maxContainerTime uint32 = 64
+	// The largest Argon2id memory (in KiB) accepted in an encrypted
+	// file, so that a crafted file can't exhaust the memory
+	maxContainerMemory uint32 = 1024 * 1024 // 1 GiB
+)
+
+// How the key of an encrypted file is obtained
+type containerMode uint8
+
+const (
+	// The key is derived from a password with Argon2id
+	modePassword containerMode = 1
+	// The key is read from a key file
+	modeKeyFile containerMode = 2
+)
+
+var (
+	// The magic bytes every encrypted file starts with
+	containerMagic = []byte("GSYM")
+	// Error returned when the input is not an encrypted file
+	errContainerFormat = errors.New("input is not a gosymcrypto encrypted file")
+	// Error returned when the encrypted file uses an unsupported version
+	errContainerVersion = errors.New("unsupported encrypted file version")
+)
+
+// The header of an encrypted file
+type containerHeader struct {
+	// The version of the format
+	version uint8
+	// How the key is obtained
+	mode containerMode
+	// Argon2id time parameter (password mode only)
+	time uint32
+	// Argon2id memory parameter in KiB (password mode only)
+	memory uint32
+	// Argon2id threads parameter (password mode only)
+	threads uint8
+	// Salt for the key derivation (password mode only)
+	salt []byte
+}
+
+// Returns the name of the mode
+func (mode containerMode) String() string {
+	switch mode {
+	case modePassword:
+		return "password (argon2id)"
+	case modeKeyFile:
+		return "key file"
+	default:
+		return "unknown"
+	}
+}
+
+// Appends the encoded header to dst
+func (header containerHeader) append(dst []byte) []byte {
+	dst = append(dst, containerMagic...)
+	dst = append(dst, header.version, uint8(header.mode))
+	if header.mode == modePassword {
+		dst = binary.BigEndian.AppendUint32(dst, header.time)
+		dst = binary.BigEndian.AppendUint32(dst, header.memory)
+		dst = append(dst, header.threads, uint8(len(header.salt)))
+		dst = append(dst, header.salt...)
+	}
+	return dst
+}
+
+// Derives the key for a password-encrypted file
+func (header containerHeader) deriveKey(password string) crypto.AESKey {
+	return crypto.DeriveSecureKey(password, header.salt, header.time, header.memory, header.threads)
+}
+
+// Parses the header of an encrypted file, returning the header, the
+// encoded header (authenticated with the ciphertext) and the
+// remaining ciphertext.
+//
+// Returns an authError for files which are malformed, use another
+// version, or Argon2id parameters too large to be safely derived.
+func parseContainer(data []byte) (header containerHeader, encodedHeader []byte, ciphertext []byte, err error) {
+	defer func() {
+		if err != nil {
+			err = authError{err}
+		}
+	}()
+	if len(data) < len(containerMagic)+2 || !bytes.Equal(data[:len(containerMagic)], containerMagic) {
+		err = errContainerFormat
+		return
+	}
+	encoded := data
+	data = data[len(containerMagic):]
+	header.version, header.mode = data[0], containerMode(data[1])
+	data = data[2:]
+	if header.version != containerVersion {
+		err = errContainerVersion
+		return
+	}
+	switch header.mode {
+	case modePassword:
+		// Time (4) + Memory (4) + Threads (1) + Salt length (1)
+		if len(data) < 10 {
+			err = errContainerFormat
+			return
+		}
+		header.time = binary.BigEndian.Uint32(data[0:4])
+		header.memory = binary.BigEndian.Uint32(data[4:8])
+		header.threads = data[8]
+		saltSize := int(data[9])
+		data = data[10:]
+		if header.time == 0 || header.time > maxContainerTime || header.memory > maxContainerMemory || header.threads == 0 ||
+			saltSize == 0 || len(data) < saltSize {
+			err = errContainerFormat
+			return
+		}
+		header.salt, data = data[:saltSize], data[saltSize:]
+	case modeKeyFile:
+	default:
+		err = errContainerFormat
+		return
+	}
+	encodedHeader, ciphertext = encoded[:len(encoded)-len(data)], data
+	return
+}
@@ -0,0 +1,155 @@
+// Command gosymcrypto encrypts and decrypts files, manages key files
+// and derives keys using the GoSymCrypto library.
+//
+// Usage:
+//
+//	gosymcrypto <command> [flags]
+//
+// The commands are:
+//
+//	encrypt   encrypt a file (or stdin) with a password or a key file
+//	decrypt   decrypt a file (or stdin) with a password or a key file
+//	keygen    generate a new random key and store it in a key file
+//	derive    derive a key from a password with Argon2id
+//	random    print a random hex string or uint64
+//	inspect   print the header of an encrypted file
+//
+// Exit codes: 0 on success, 1 on generic errors, 2 on usage errors,
+// 3 on I/O errors and 4 on authentication failures (wrong password,
+// wrong key, or tampered or malformed data).
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// Exit code on success
+	exitOK = 0
+	// Exit code for errors that don't fit any other category
+	exitError = 1
+	// Exit code when the command line is invalid
+	exitUsage = 2
+	// Exit code when reading or writing data failed
+	exitIO = 3
+	// Exit code when authentication failed (wrong password, wrong key,
+	// or tampered or malformed data)
+	exitAuth = 4
+)
+
+// A subcommand of the tool
+type command struct {
+	// The name of the command
+	name string
+	// A short description of the command
+	description string
+	// Runs the command
+	run func(env *environment, args []string) error
+}
+
+// The streams a command can use
+type environment struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var (
+	// The list of supported commands
+	commands = []command{
+		{"encrypt", "encrypt a file (or stdin) with a password or a key file", runEncrypt},
+		{"decrypt", "decrypt a file (or stdin) with a password or a key file", runDecrypt},
+		{"keygen", "generate a new random key and store it in a key file", runKeygen},
+		{"derive", "derive a key from a password with Argon2id", runDerive},
+		{"random", "print a random hex string or uint64", runRandom},
+		{"inspect", "print the header of an encrypted file", runInspect},
+	}
+)
+
+// An error reading or writing data
+type ioError struct{ err error }
+
+func (e ioError) Error() string { return e.err.Error() }
+func (e ioError) Unwrap() error { return e.err }
+
+// An error authenticating data (wrong password, key or tampered data)
+type authError struct{ err error }
+
+func (e authError) Error() string { return e.err.Error() }
+func (e authError) Unwrap() error { return e.err }
+
+// An invalid command line
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func main() {
+	os.Exit(run(os.Args[1:], &environment{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}))
+}
+
+// Runs the tool with the given arguments and returns the exit code
+func run(args []string, env *environment) int {
+	if len(args) == 0 {
+		usage(env.stderr)
+		return exitUsage
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(env.stdout)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(env, args[1:])
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		if err != nil {
+			fmt.Fprintf(env.stderr, "gosymcrypto %s: %v\n", name, err)
+		}
+		return exitCode(err)
+	}
+	fmt.Fprintf(env.stderr, "gosymcrypto: unknown command %q\n", name)
+	usage(env.stderr)
+	return exitUsage
+}
+
+// Returns the exit code matching an error
+func exitCode(err error) int {
+	var (
+		ioErr    ioError
+		authErr  authError
+		usageErr usageError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &authErr):
+		return exitAuth
+	case errors.As(err, &ioErr):
+		return exitIO
+	default:
+		return exitError
+	}
+}
+
+// Prints the usage of the tool
+func usage(writer io.Writer) {
+	fmt.Fprintln(writer, "Usage: gosymcrypto <command> [flags]")
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(writer, "  %-9s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "Run 'gosymcrypto <command> -h' for the flags of a command.")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecryptPassword(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	dir := t.TempDir()
+	passwordFile := writeTestFile(t, dir, "password", "hello gopher\n")
+	wrongPasswordFile := writeTestFile(t, dir, "wrong", "hello")
+	plaintext := "some secret data"
+	// Encrypt from stdin
+	ciphertext, code := runTest(t, plaintext, "encrypt", "-password-file", passwordFile)
+	if code != exitOK {
+		t.Fatalf("encrypt exited with %d", code)
+	}
+	if strings.Contains(ciphertext, plaintext) {
+		t.Fatalf("the ciphertext contains the plaintext")
+	}
+	// Decrypt to stdout
+	decrypted, code := runTest(t, ciphertext, "decrypt", "-password-file", passwordFile)
+	if code != exitOK || decrypted != plaintext {
+		t.Fatalf("decrypt exited with %d and returned %q", code, decrypted)
+	}
+	// Wrong password
+	if _, code = runTest(t, ciphertext, "decrypt", "-password-file", wrongPasswordFile); code != exitAuth {
+		t.Fatalf("expected exit code %d with the wrong password, instead got %d", exitAuth, code)
+	}
+	// Tampered data
+	tampered := []byte(ciphertext)
+	tampered[len(tampered)-1] ^= 1
+	if _, code = runTest(t, string(tampered), "decrypt", "-password-file", passwordFile); code != exitAuth {
+		t.Fatalf("expected exit code %d with tampered data, instead got %d", exitAuth, code)
+	}
+	// Tampered header (the Argon2id time, then a memory too large to derive)
+	tampered = []byte(ciphertext)
+	binary.BigEndian.PutUint32(tampered[6:10], 1)
+	if _, code = runTest(t, string(tampered), "decrypt", "-password-file", passwordFile); code != exitAuth {
+		t.Fatalf("expected exit code %d with a tampered header, instead got %d", exitAuth, code)
+	}
+	binary.BigEndian.PutUint32(tampered[10:14], maxContainerMemory+1)
+	if _, code = runTest(t, string(tampered), "decrypt", "-password-file", passwordFile); code != exitAuth {
+		t.Fatalf("expected exit code %d with a huge memory, instead got %d", exitAuth, code)
+	}
+	// Inspect
+	info, code := runTest(t, ciphertext, "inspect")
+	if code != exitOK || !strings.Contains(info, "argon2id") {
+		t.Fatalf("inspect exited with %d and returned %q", code, info)
+	}
+}
+
+func TestEncryptDecryptKeyFile(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	dir := t.TempDir()
+	passwordFile := writeTestFile(t, dir, "password", "key file passphrase")
+	keyFile := filepath.Join(dir, "key")
+	input := writeTestFile(t, dir, "input", "some secret file")
+	encrypted := filepath.Join(dir, "encrypted")
+	decrypted := filepath.Join(dir, "decrypted")
+	if _, code := runTest(t, "", "keygen", "-out", keyFile, "-password-file", passwordFile); code != exitOK {
+		t.Fatalf("keygen exited with %d", code)
+	}
+	if _, code := runTest(t, "", "encrypt", "-key", keyFile, "-password-file", passwordFile, "-in", input, "-out", encrypted); code != exitOK {
+		t.Fatalf("encrypt exited with %d", code)
+	}
+	if _, code := runTest(t, "", "decrypt", "-password-file", passwordFile, "-in", encrypted); code != exitUsage {
+		t.Fatalf("expected exit code %d when decrypting without -key, instead got %d", exitUsage, code)
+	}
+	// An existing output file is replaced by a private one
+	if err := os.WriteFile(decrypted, []byte("public"), 0o644); err != nil {
+		t.Fatalf("failed to write %q: %v", decrypted, err)
+	}
+	if _, code := runTest(t, "", "decrypt", "-key", keyFile, "-password-file", passwordFile, "-in", encrypted, "-out", decrypted); code != exitOK {
+		t.Fatalf("decrypt exited with %d", code)
+	}
+	data, err := os.ReadFile(decrypted)
+	if err != nil || string(data) != "some secret file" {
+		t.Fatalf("decrypted file contains %q (%v)", data, err)
+	}
+	if info, err := os.Stat(decrypted); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0o600) {
+		t.Fatalf("expected the decrypted file to be private, instead got %v (%v)", info.Mode(), err)
+	}
+	// Corrupted, unsupported and public key files
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", keyFile, err)
+	}
+	unsupported := bytes.Clone(keyData)
+	unsupported[4]++
+	for name, testCase := range map[string]struct {
+		data []byte
+		perm os.FileMode
+		code int
+	}{
+		"truncated":   {data: keyData[:len(keyData)-1], perm: 0o600, code: exitAuth},
+		"unsupported": {data: unsupported, perm: 0o600, code: exitAuth},
+		"public":      {data: keyData, perm: 0o644, code: exitIO},
+	} {
+		if runtime.GOOS == "windows" && name == "public" {
+			// File permissions are not checked on windows
+			continue
+		}
+		badKeyFile := filepath.Join(dir, name)
+		if err := os.WriteFile(badKeyFile, testCase.data, testCase.perm); err != nil {
+			t.Fatalf("failed to write %q: %v", badKeyFile, err)
+		}
+		if _, code := runTest(t, "", "decrypt", "-key", badKeyFile, "-password-file", passwordFile, "-in", encrypted); code != testCase.code {
+			t.Fatalf("%s: expected exit code %d, instead got %d", name, testCase.code, code)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	testCases := []struct {
+		args  []string
+		stdin string
+		code  int
+	}{
+		{args: []string{}, code: exitUsage},
+		{args: []string{"help"}, code: exitOK},
+		{args: []string{"unknown"}, code: exitUsage},
+		{args: []string{"encrypt", "-unknown"}, code: exitUsage},
+		{args: []string{"encrypt", "extra"}, code: exitUsage},
+		{args: []string{"encrypt", "-in", missing}, code: exitIO},
+		{args: []string{"encrypt", "-password-file", missing}, stdin: "data", code: exitIO},
+		{args: []string{"inspect"}, stdin: "not encrypted", code: exitAuth},
+		{args: []string{"random", "-length", "-1"}, code: exitUsage},
+		{args: []string{"derive", "-threads", "256"}, code: exitUsage},
+		{args: []string{"derive"}, code: exitUsage},
+		{args: []string{"derive", "-salt", "00"}, code: exitUsage},
+		{args: []string{"keygen"}, code: exitUsage},
+	}
+	for _, testCase := range testCases {
+		if _, code := runTest(t, testCase.stdin, testCase.args...); code != testCase.code {
+			t.Fatalf("expected exit code %d for %q, instead got %d", testCase.code, testCase.args, code)
+		}
+	}
+}
+
+func TestDeriveRandom(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	passwordFile := writeTestFile(t, t.TempDir(), "password", "somepassword")
+	// See the argon2id test cases in internal/helpers
+	key, code := runTest(t, "", "derive", "-salt", "736f6d6573616c74", "-time", "3", "-memory", "4096", "-threads", "1", "-password-file", passwordFile)
+	if code != exitOK || key != "e802d7f1de82db6df4a05d0c72d09e79d599ed3776fe538bf9ddf6cc5e890925\n" {
+		t.Fatalf("derive exited with %d and returned %q", code, key)
+	}
+	// A salt file is created once and then reused
+	saltFile := filepath.Join(t.TempDir(), "salt")
+	first, code := runTest(t, "", "derive", "-salt-file", saltFile, "-time", "1", "-memory", "64", "-threads", "1", "-password-file", passwordFile)
+	if code != exitOK || len(first) != 65 {
+		t.Fatalf("derive exited with %d and returned %q", code, first)
+	}
+	if second, code := runTest(t, "", "derive", "-salt-file", saltFile, "-time", "1", "-memory", "64", "-threads", "1", "-password-file", passwordFile); code != exitOK || second != first {
+		t.Fatalf("derive exited with %d and returned %q instead of %q", code, second, first)
+	}
+	random, code := runTest(t, "", "random", "-length", "7")
+	if code != exitOK || len(random) != 8 {
+		t.Fatalf("random exited with %d and returned %q", code, random)
+	}
+}
+
+// Runs the tool with the given stdin and returns stdout and the exit code
+func runTest(t *testing.T, stdin string, args ...string) (stdout string, code int) {
+	output, errors := &bytes.Buffer{}, &bytes.Buffer{}
+	code = run(args, &environment{stdin: strings.NewReader(stdin), stdout: output, stderr: errors})
+	if errors.Len() > 0 {
+		t.Logf("gosymcrypto %q: %s", args, errors.String())
+	}
+	return output.String(), code
+}
+
+// Writes a file in dir and returns its path
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+	return path
+}
@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	// The ioctl request to read the terminal state
+	ioctlReadTermios = unix.TIOCGETA
+	// The ioctl request to write the terminal state
+	ioctlWriteTermios = unix.TIOCSETA
+)
@@ -0,0 +1,10 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	// The ioctl request to read the terminal state
+	ioctlReadTermios = unix.TCGETS
+	// The ioctl request to write the terminal state
+	ioctlWriteTermios = unix.TCSETS
+)
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// Prompting without echo is not supported on this platform
+func promptPassword(prompt string) (password string, err error) {
+	return "", errors.New("password prompts are not supported on this platform (use -password-file)")
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Prompts for a password on the terminal without echoing it
+func promptPassword(prompt string) (password string, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("cannot prompt for a password without a terminal (use -password-file): %w", err)
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	// Disable echo, restoring the terminal state once done
+	state, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return
+	}
+	noEcho := *state
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	noEcho.Iflag |= unix.ICRNL
+	if err = unix.IoctlSetTermios(fd, ioctlWriteTermios, &noEcho); err != nil {
+		return
+	}
+	defer func() {
+		_ = unix.IoctlSetTermios(fd, ioctlWriteTermios, state)
+		_, _ = tty.WriteString("\n")
+	}()
+	if _, err = tty.WriteString(prompt); err != nil {
+		return
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return
+	}
+	password = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return
+}
@@ -2,6 +2,7 @@ module github.com/stefanovazzocell/GoSymCryto
 
 go 1.21.5
 
-require golang.org/x/crypto v0.17.0
-
-require golang.org/x/sys v0.15.0 // indirect
+require (
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sys v0.15.0
+)
@@ -1,9 +1,12 @@
 package helpers
 
 import (
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
 )
 
 var (
@@ -17,17 +20,28 @@ var (
 
 // Encrypts, encodes and writes data to writer
 func WriteEncrypted(writer io.Writer, key [KeySize]byte, data []byte) (err error) {
-	ciphertext, err := Encrypt(key, data)
+	return WriteEncryptedFrom(writer, FramerUint64, rand.Reader, key, data)
+}
+
+// Encrypts (reading the nonce from random), encodes (with framer)
+// and writes data to writer
+func WriteEncryptedFrom(writer io.Writer, framer Framer, random io.Reader, key [KeySize]byte, data []byte) (err error) {
+	ciphertext, err := EncryptFrom(random, key, data)
 	if err != nil {
 		data = nil
 		return
 	}
-	return Encode(writer, ciphertext)
+	return EncodeFrame(writer, framer, ciphertext)
 }
 
 // Reads, decodes, and decrypts data
 func ReadEncrypted(reader io.Reader, key [KeySize]byte) (data []byte, err error) {
-	ciphertext, err := Decode(reader)
+	return DecodeEncrypted(NewDecoder(reader, math.MaxUint64), key)
+}
+
+// Reads a frame from decoder and decrypts it
+func DecodeEncrypted(decoder *Decoder, key [KeySize]byte) (data []byte, err error) {
+	ciphertext, err := decoder.Decode()
 	if err != nil {
 		data = nil
 		return
@@ -39,16 +53,50 @@ func ReadEncrypted(reader io.Reader, key [KeySize]byte) (data []byte, err error)
 //
 // The provided sequenceNumber will be encoded with the data
 func WriteEncryptedMessage(writer io.Writer, key [KeySize]byte, sequenceNumber uint64, data []byte) (err error) {
+	return WriteEncryptedMessageFrom(writer, FramerUint64, rand.Reader, key, sequenceNumber, data)
+}
+
+// Encrypts (reading the nonce from random), encodes (with framer)
+// and writes data to writer
+//
+// The provided sequenceNumber will be encoded with the data
+func WriteEncryptedMessageFrom(writer io.Writer, framer Framer, random io.Reader, key [KeySize]byte, sequenceNumber uint64, data []byte) (err error) {
 	// Encode and send the message
-	return WriteEncrypted(writer, key, append(binary.BigEndian.AppendUint64(nil, sequenceNumber), data...))
+	return WriteEncryptedFrom(writer, framer, random, key, append(binary.BigEndian.AppendUint64(nil, sequenceNumber), data...))
 }
 
 // Reads, decodes, and decrypts data
 //
 // If the sequenceNumber received doesn't match the expected value or is not present, an error will be returned
 func ReadEncryptedMessage(reader io.Reader, key [KeySize]byte, sequenceNumber uint64) (data []byte, err error) {
-	// Read and decrypt message
-	data, err = ReadEncrypted(reader, key)
+	return DecodeEncryptedMessage(NewDecoder(reader, math.MaxUint64), key, sequenceNumber)
+}
+
+// Reads a frame from decoder and decrypts it
+//
+// If the sequenceNumber received doesn't match the expected value or is not present, an error will be returned
+func DecodeEncryptedMessage(decoder *Decoder, key [KeySize]byte, sequenceNumber uint64) (data []byte, err error) {
+	// Read message
+	frame, err := decoder.Decode()
+	if err != nil {
+		return
+	}
+	// Decrypt message and check sequence number
+	return OpenMessage(NewAEAD(key), frame, sequenceNumber)
+}
+
+// Decrypts a frame from `WriteEncryptedMessage` with aead in place,
+// and returns the data (a slice of frame) without the sequence number
+//
+// If the sequenceNumber received doesn't match the expected value or is not present, an error will be returned
+func OpenMessage(aead cipher.AEAD, frame []byte, sequenceNumber uint64) (data []byte, err error) {
+	if len(frame) < NonceSize {
+		err = ErrInvalidCiphertextSize
+		return
+	}
+	// Decrypt message, reusing the frame for the plaintext
+	ciphertext := frame[NonceSize:]
+	data, err = aead.Open(ciphertext[:0], frame[:NonceSize], ciphertext, nil)
 	if err != nil {
 		data = nil
 		return
@@ -134,3 +134,44 @@ func TestWriteReadEncryptedMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenMessage(t *testing.T) {
+	// Can't run in parallel (AllocsPerRun)
+	key := helpers.DeriveKey("")
+	aead := helpers.NewAEAD(key)
+	buffer := bytes.NewBuffer([]byte{})
+	if err := helpers.WriteEncryptedMessage(buffer, key, 42, []byte("hello world")); err != nil {
+		t.Fatalf("failed to encrypt message: %v", err)
+	}
+	encoded := buffer.Bytes()
+	// Decoding and decrypting into the same buffer doesn't allocate
+	reader := bytes.NewReader(encoded)
+	decoder := helpers.NewDecoder(reader, 0)
+	frame := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		reader.Reset(encoded)
+		decoded, err := decoder.DecodeAppend(frame[:0])
+		if err != nil {
+			t.Fatalf("failed to decode frame: %v", err)
+		}
+		data, err := helpers.OpenMessage(aead, decoded, 42)
+		if err != nil || string(data) != "hello world" {
+			t.Fatalf("unexpected message %q (%v)", data, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, instead got %v", allocs)
+	}
+	// Errors
+	reader.Reset(encoded)
+	decoded, err := decoder.DecodeAppend(frame[:0])
+	if err != nil {
+		t.Fatalf("failed to decode frame: %v", err)
+	}
+	if data, err := helpers.OpenMessage(aead, decoded, 43); data != nil || err != helpers.ErrInvalidRemoteMessageChallenge {
+		t.Fatalf("expected ErrInvalidRemoteMessageChallenge, instead got (%x, %v)", data, err)
+	}
+	if data, err := helpers.OpenMessage(aead, decoded[:helpers.NonceSize-1], 42); data != nil || err != helpers.ErrInvalidCiphertextSize {
+		t.Fatalf("expected ErrInvalidCiphertextSize, instead got (%x, %v)", data, err)
+	}
+}
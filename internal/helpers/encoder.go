@@ -1,35 +1,226 @@
 package helpers
 
 import (
+	"crypto/cipher"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"slices"
 )
 
+const (
+	// The default maximum frame size for a `Decoder` (16 MiB)
+	DefaultMaxFrameSize = 16 << 20
+	// The size of the first read of a frame; the buffer then grows
+	// as data arrives, so that the allocation is bounded by the
+	// data actually received rather than the announced length
+	decoderChunkSize = 64 << 10
+	// The largest buffer an `Encoder` keeps after a `Flush`
+	encoderRetainedBufferSize = 64 << 10
+)
+
+var (
+	// Error matched (with errors.Is) by `FrameTooLargeError`
+	ErrFrameTooLarge = errors.New("frame is larger than the maximum frame size")
+)
+
+// The error returned when a frame is larger than the maximum size
+// of a `Decoder`
+type FrameTooLargeError struct {
+	// The announced size of the frame
+	Size uint64
+	// The maximum frame size
+	MaxSize uint64
+}
+
+// Reads frames written by `Encode`, refusing frames larger than a
+// maximum size before allocating memory for them
+type Decoder struct {
+	// The underlying reader
+	reader io.Reader
+	// Reads the length prefixes
+	framer Framer
+	// The maximum frame size
+	maxFrameSize uint64
+}
+
 // Writes a block of data of into a writer in such a way
 // that we can later extract the same data
 func Encode(writer io.Writer, data []byte) (err error) {
-	// Write the data length to a bytes buffer
-	buffer := binary.BigEndian.AppendUint64(nil, uint64(len(data)))
-	// Write the buffer to the writer
-	if _, err = writer.Write(buffer); err != nil {
-		return
-	}
-	// Now write the data
-	_, err = writer.Write(data)
-	return
+	return EncodeFrame(writer, FramerUint64, data)
 }
 
 // Reads and returns a block of data from a reader in such
 // a way that it matches the data from a encoder
+//
+// There is no limit on the size of the block; use a `Decoder`
+// to set one.
 func Decode(reader io.Reader) (data []byte, err error) {
+	return NewDecoder(reader, math.MaxUint64).Decode()
+}
+
+// Creates a `Decoder` reading frames of at most maxFrameSize bytes
+// from reader (or `DefaultMaxFrameSize` bytes if maxFrameSize is 0)
+func NewDecoder(reader io.Reader, maxFrameSize uint64) *Decoder {
+	return NewFramedDecoder(reader, FramerUint64, maxFrameSize)
+}
+
+// Creates a `Decoder` reading frames prefixed by framer (see
+// `NewDecoder`)
+func NewFramedDecoder(reader io.Reader, framer Framer, maxFrameSize uint64) *Decoder {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Decoder{reader: reader, framer: framer, maxFrameSize: maxFrameSize}
+}
+
+// Reads and returns the next frame.
+//
+// Returns a `*FrameTooLargeError` (without reading the frame) if it
+// is larger than the maximum frame size.
+func (decoder *Decoder) Decode() (data []byte, err error) {
+	return decoder.DecodeAppend(nil)
+}
+
+// Reads the next frame and appends it to dst, reusing the capacity
+// of dst (see `Decode`).
+//
+// On error, returns dst unchanged so that the caller can reuse it.
+func (decoder *Decoder) DecodeAppend(dst []byte) (data []byte, err error) {
 	// Read the length of the next data block
-	buffer := make([]byte, BytesFor64Bit)
-	if _, err = io.ReadFull(reader, buffer); err != nil {
+	length, err := decoder.framer.ReadLength(decoder.reader)
+	if err != nil {
+		return dst, err
+	}
+	if length > decoder.maxFrameSize || length > math.MaxInt {
+		return dst, &FrameTooLargeError{Size: length, MaxSize: decoder.maxFrameSize}
+	}
+	// Read the data (given the length we decoded), growing the
+	// buffer as it arrives
+	start := len(dst)
+	data = dst
+	for read := uint64(0); read < length; {
+		if len(data) == cap(data) {
+			data = slices.Grow(data, int(min(length-read, max(read, decoderChunkSize))))
+		}
+		n, err := io.ReadFull(decoder.reader, data[len(data):start+int(min(uint64(cap(data)-start), length))])
+		data = data[:len(data)+n]
+		read += uint64(n)
+		if err == io.EOF {
+			// Some of the frame was expected
+			return dst, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return
+}
+
+// Describes the frame size and the limit
+func (err *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("%v (%d > %d bytes)", ErrFrameTooLarge, err.Size, err.MaxSize)
+}
+
+// Matches `ErrFrameTooLarge`
+func (err *FrameTooLargeError) Is(target error) bool {
+	return target == ErrFrameTooLarge
+}
+
+// Writes frames into a writer with a single Write call per frame,
+// or per batch of frames (see `Flush`)
+type Encoder struct {
+	// The underlying writer
+	writer io.Writer
+	// Writes the length prefixes
+	framer Framer
+	// The frames not written yet
+	buffer []byte
+}
+
+// Creates an `Encoder` writing frames prefixed by framer into writer
+func NewEncoder(writer io.Writer, framer Framer) *Encoder {
+	return &Encoder{writer: writer, framer: framer}
+}
+
+// Writes a frame with data
+func (encoder *Encoder) Encode(data []byte) (err error) {
+	if err = encoder.Append(data); err != nil {
+		return
+	}
+	return encoder.Flush()
+}
+
+// Queues a frame with data, to be written by `Flush`
+func (encoder *Encoder) Append(data []byte) (err error) {
+	buffer, err := encoder.framer.AppendLength(encoder.buffer, uint64(len(data)))
+	if err != nil {
+		return
+	}
+	encoder.buffer = append(buffer, data...)
+	return
+}
+
+// Queues a frame with data encrypted by aead (see `Seal`), to be
+// written by `Flush`
+func (encoder *Encoder) AppendSealed(aead cipher.AEAD, random io.Reader, data []byte) (err error) {
+	return encoder.appendSealed(aead, random, nil, data)
+}
+
+// Queues a frame with sequenceNumber and data encrypted by aead (see
+// `WriteEncryptedMessage`), to be written by `Flush`
+func (encoder *Encoder) AppendMessage(aead cipher.AEAD, random io.Reader, sequenceNumber uint64, data []byte) (err error) {
+	var header [BytesFor64Bit]byte
+	binary.BigEndian.PutUint64(header[:], sequenceNumber)
+	return encoder.appendSealed(aead, random, header[:], data)
+}
+
+// Writes the queued frames with a single Write call.
+//
+// The queued frames are dropped even if the write fails, as the
+// stream can't be resumed after a partial write.
+func (encoder *Encoder) Flush() (err error) {
+	if len(encoder.buffer) == 0 {
+		return
+	}
+	_, err = encoder.writer.Write(encoder.buffer)
+	// Keep small buffers for the next frames
+	if cap(encoder.buffer) > encoderRetainedBufferSize {
+		encoder.buffer = nil
+	} else {
+		encoder.buffer = encoder.buffer[:0]
+	}
+	return
+}
+
+// Returns the number of bytes queued
+func (encoder *Encoder) Buffered() int {
+	return len(encoder.buffer)
+}
+
+// Queues a frame with header and data encrypted by aead, encrypting
+// in place in the buffer
+func (encoder *Encoder) appendSealed(aead cipher.AEAD, random io.Reader, header []byte, data []byte) (err error) {
+	plaintextLength := len(header) + len(data)
+	buffer, err := encoder.framer.AppendLength(encoder.buffer, uint64(NonceSize+plaintextLength+aead.Overhead()))
+	if err != nil {
+		return
+	}
+	start := len(buffer)
+	buffer = append(buffer, make([]byte, NonceSize)...)
+	if _, err = io.ReadFull(random, buffer[start:]); err != nil {
 		return
 	}
-	length := binary.BigEndian.Uint64(buffer)
-	// Read the data (given the length we decoded)
-	data = make([]byte, length)
-	_, err = io.ReadFull(reader, data)
+	buffer = append(append(buffer, header...), data...)
+	// Make room for the tag so that Seal doesn't reallocate
+	buffer = slices.Grow(buffer, aead.Overhead())
+	plaintext := buffer[start+NonceSize:]
+	aead.Seal(plaintext[:0], buffer[start:start+NonceSize], plaintext, nil)
+	encoder.buffer = buffer[:len(buffer)+aead.Overhead()]
 	return
 }
@@ -2,7 +2,11 @@ package helpers_test
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"io"
+	"math"
 	"testing"
 
 	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
@@ -19,6 +23,13 @@ var (
 		{data: []byte{50, 42, 10}, encoded: []byte{0, 0, 0, 0, 0, 0, 0, 3, 50, 42, 10}},
 		{data: make([]byte, 1000), encoded: append([]byte{0, 0, 0, 0, 0, 0, 0x3, 0xe8}, make([]byte, 1000)...)},
 	}
+	// The framers by name
+	framers = map[string]helpers.Framer{
+		"uvarint": helpers.FramerUvarint,
+		"uint16":  helpers.FramerUint16,
+		"uint32":  helpers.FramerUint32,
+		"uint64":  helpers.FramerUint64,
+	}
 	// Test cases for the encoding benchmarks
 	encodingBenchmarksTestCases = []struct {
 		name string
@@ -59,6 +70,167 @@ func TestEncoding(t *testing.T) {
 	}
 }
 
+func TestEncoder(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	aead := helpers.NewAEAD(key)
+	for name, framer := range framers {
+		writer := &countingWriter{}
+		encoder := helpers.NewEncoder(writer, framer)
+		// One write per frame
+		if err := encoder.Encode([]byte("plain")); err != nil || writer.writes != 1 {
+			t.Fatalf("%s: expected 1 write, instead got %d (%v)", name, writer.writes, err)
+		}
+		// One write per batch
+		if err := encoder.AppendSealed(aead, rand.Reader, []byte("sealed")); err != nil {
+			t.Fatalf("%s: failed to append: %v", name, err)
+		}
+		if err := encoder.AppendMessage(aead, rand.Reader, 42, []byte("message")); err != nil {
+			t.Fatalf("%s: failed to append: %v", name, err)
+		}
+		if writer.writes != 1 || encoder.Buffered() == 0 {
+			t.Fatalf("%s: expected the frames to be queued, instead got %d writes", name, writer.writes)
+		}
+		if err := encoder.Flush(); err != nil || writer.writes != 2 || encoder.Buffered() != 0 {
+			t.Fatalf("%s: expected 2 writes, instead got %d (%v)", name, writer.writes, err)
+		}
+		if err := encoder.Flush(); err != nil || writer.writes != 2 {
+			t.Fatalf("%s: expected no write for an empty flush, instead got %d (%v)", name, writer.writes, err)
+		}
+		// The frames match the other helpers
+		decoder := helpers.NewFramedDecoder(&writer.buffer, framer, 0)
+		if data, err := decoder.Decode(); err != nil || string(data) != "plain" {
+			t.Fatalf("%s: unexpected frame %q (%v)", name, data, err)
+		}
+		if data, err := helpers.DecodeEncrypted(decoder, key); err != nil || string(data) != "sealed" {
+			t.Fatalf("%s: unexpected frame %q (%v)", name, data, err)
+		}
+		if data, err := helpers.DecodeEncryptedMessage(decoder, key, 42); err != nil || string(data) != "message" {
+			t.Fatalf("%s: unexpected frame %q (%v)", name, data, err)
+		}
+	}
+	// Frames too long for the prefix are not queued
+	encoder := helpers.NewEncoder(&countingWriter{}, helpers.FramerUint16)
+	if err := encoder.Append(make([]byte, math.MaxUint16+1)); !errors.Is(err, helpers.ErrFrameLength) || encoder.Buffered() != 0 {
+		t.Fatalf("expected ErrFrameLength, instead got %v", err)
+	}
+}
+
+// A writer counting the calls to Write
+type countingWriter struct {
+	buffer bytes.Buffer
+	writes int
+}
+
+func (writer *countingWriter) Write(p []byte) (n int, err error) {
+	writer.writes++
+	return writer.buffer.Write(p)
+}
+
+func TestFramers(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	testCases := []struct {
+		framer   string
+		length   uint64
+		expected []byte
+	}{
+		{"uvarint", 0, []byte{0}},
+		{"uvarint", 127, []byte{0x7f}},
+		{"uvarint", 128, []byte{0x80, 0x01}},
+		{"uvarint", 300, []byte{0xac, 0x02}},
+		{"uvarint", math.MaxUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"uint16", 0, []byte{0, 0}},
+		{"uint16", 300, []byte{0x01, 0x2c}},
+		{"uint16", math.MaxUint16, []byte{0xff, 0xff}},
+		{"uint32", 300, []byte{0, 0, 0x01, 0x2c}},
+		{"uint32", math.MaxUint32, []byte{0xff, 0xff, 0xff, 0xff}},
+		{"uint64", 300, []byte{0, 0, 0, 0, 0, 0, 0x01, 0x2c}},
+		{"uint64", math.MaxUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, testCase := range testCases {
+		framer := framers[testCase.framer]
+		encoded, err := framer.AppendLength([]byte{42}, testCase.length)
+		if err != nil || !bytes.Equal(encoded[1:], testCase.expected) || encoded[0] != 42 {
+			t.Fatalf("%s: expected %x for %d, instead got %x (%v)", testCase.framer, testCase.expected, testCase.length, encoded, err)
+		}
+		reader := bytes.NewReader(append(testCase.expected, 42))
+		if length, err := framer.ReadLength(reader); err != nil || length != testCase.length || reader.Len() != 1 {
+			t.Fatalf("%s: expected %d from %x, instead got %d (%v)", testCase.framer, testCase.length, testCase.expected, length, err)
+		}
+	}
+	// Lengths too large for the prefix
+	for name, length := range map[string]uint64{"uint16": math.MaxUint16 + 1, "uint32": math.MaxUint32 + 1} {
+		if _, err := framers[name].AppendLength(nil, length); !errors.Is(err, helpers.ErrFrameLength) {
+			t.Fatalf("%s: expected ErrFrameLength for %d, instead got %v", name, length, err)
+		}
+		if framers[name].MaxLength() != length-1 {
+			t.Fatalf("%s: unexpected MaxLength %d", name, framers[name].MaxLength())
+		}
+	}
+	if err := helpers.EncodeFrame(io.Discard, helpers.FramerUint16, make([]byte, math.MaxUint16+1)); !errors.Is(err, helpers.ErrFrameLength) {
+		t.Fatalf("expected ErrFrameLength, instead got %v", err)
+	}
+	// Malformed uvarints
+	for _, encoded := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+	} {
+		if _, err := helpers.FramerUvarint.ReadLength(bytes.NewReader(encoded)); err != helpers.ErrFrameUvarint {
+			t.Fatalf("expected ErrFrameUvarint for %x, instead got %v", encoded, err)
+		}
+	}
+	if _, err := helpers.FramerUvarint.ReadLength(bytes.NewReader([]byte{0x80})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, instead got %v", err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Frames within the limit, including one larger than the first read
+	large := bytes.Repeat([]byte("0123456789"), 20_000)
+	buffer := bytes.NewBuffer([]byte{})
+	for _, data := range [][]byte{{}, []byte("hello"), large} {
+		if err := helpers.Encode(buffer, data); err != nil {
+			t.Fatalf("failed to encode %d bytes: %v", len(data), err)
+		}
+	}
+	decoder := helpers.NewDecoder(buffer, uint64(len(large)))
+	for _, data := range [][]byte{{}, []byte("hello"), large} {
+		if decoded, err := decoder.Decode(); err != nil || !bytes.Equal(decoded, data) {
+			t.Fatalf("failed to decode %d bytes: got %d bytes (%v)", len(data), len(decoded), err)
+		}
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Fatalf("expected io.EOF, instead got %v", err)
+	}
+	// Frames over the limit are refused before reading them
+	for _, length := range []uint64{uint64(len(large)) + 1, math.MaxUint64} {
+		encoded := binary.BigEndian.AppendUint64(nil, length)
+		_, err := helpers.NewDecoder(bytes.NewReader(encoded), uint64(len(large))).Decode()
+		var tooLarge *helpers.FrameTooLargeError
+		if !errors.As(err, &tooLarge) || !errors.Is(err, helpers.ErrFrameTooLarge) || tooLarge.Size != length {
+			t.Fatalf("expected a FrameTooLargeError for %d bytes, instead got %v", length, err)
+		}
+	}
+	// The default limit
+	encoded := binary.BigEndian.AppendUint64(nil, helpers.DefaultMaxFrameSize+1)
+	if _, err := helpers.NewDecoder(bytes.NewReader(encoded), 0).Decode(); !errors.Is(err, helpers.ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, instead got %v", err)
+	}
+	// A frame announcing more data than it has only allocates what
+	// was received before failing
+	encoded = append(binary.BigEndian.AppendUint64(nil, 1<<40), "short"...)
+	if _, err := helpers.Decode(bytes.NewReader(encoded)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, instead got %v", err)
+	}
+	// DecodeAppend returns dst on errors
+	dst := append(make([]byte, 0, 64), "kept"...)
+	decoded, err := helpers.NewDecoder(bytes.NewReader(encoded), math.MaxUint64).DecodeAppend(dst)
+	if err != io.ErrUnexpectedEOF || string(decoded) != "kept" || &decoded[:1][0] != &dst[0] {
+		t.Fatalf("expected dst and io.ErrUnexpectedEOF, instead got %q (%v)", decoded, err)
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	for _, test := range encodingBenchmarksTestCases {
 		data := make([]byte, test.size)
@@ -107,6 +279,44 @@ func FuzzEncoding(f *testing.F) {
 		if !bytes.Equal(decoded, data) {
 			t.Fatalf("there is a mismatch in the final result: expected %x, but decoded %x", data, decoded)
 		}
+		// Every framer
+		for name, framer := range framers {
+			buffer.Reset()
+			if err := helpers.EncodeFrame(buffer, framer, data); err != nil {
+				t.Fatalf("%s: failed to encode %x: %v", name, data, err)
+			}
+			decoded, err := helpers.NewFramedDecoder(buffer, framer, uint64(len(data))+1).Decode()
+			if err != nil {
+				t.Fatalf("%s: failed to decode data from %x: %v", name, data, err)
+			}
+			if !bytes.Equal(decoded, data) || buffer.Len() != 0 {
+				t.Fatalf("%s: there is a mismatch in the final result: expected %x, but decoded %x", name, data, decoded)
+			}
+		}
+	})
+}
+
+func FuzzFramerReadLength(f *testing.F) {
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x80})
+	f.Add([]byte{0, 1})
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		for name, framer := range framers {
+			// Any length read must encode back to the same prefix
+			reader := bytes.NewReader(encoded)
+			length, err := framer.ReadLength(reader)
+			if err != nil {
+				continue
+			}
+			prefix, err := framer.AppendLength(nil, length)
+			if err != nil {
+				t.Fatalf("%s: failed to encode length %d read from %x: %v", name, length, encoded, err)
+			}
+			if consumed := encoded[:len(encoded)-reader.Len()]; name != "uvarint" && !bytes.Equal(prefix, consumed) {
+				t.Fatalf("%s: length %d read from %x encodes to %x", name, length, consumed, prefix)
+			}
+		}
 	})
 }
 
@@ -15,6 +15,8 @@ const (
 	// The key size.
 	// note: using 32-byte for AES-GCM-256
 	KeySize = 32
+	// The size of the GCM authentication tag
+	TagSize = 16
 )
 
 var (
@@ -25,56 +27,74 @@ var (
 
 // Encrypt some plaintext data with a key
 func Encrypt(key [KeySize]byte, plaintext []byte) (ciphertext []byte, err error) {
+	return EncryptFrom(rand.Reader, key, plaintext)
+}
+
+// Encrypt some plaintext data with a key, reading the nonce from random
+func EncryptFrom(random io.Reader, key [KeySize]byte, plaintext []byte) (ciphertext []byte, err error) {
+	return Seal(NewAEAD(key), random, nil, plaintext)
+}
+
+// Encrypts plaintext with aead and a nonce read from random, and
+// appends the nonce followed by the sealed data to dst
+func Seal(aead cipher.AEAD, random io.Reader, dst []byte, plaintext []byte) (ciphertext []byte, err error) {
+	return SealWithData(aead, random, dst, plaintext, nil)
+}
+
+// Like `Seal`, also authenticating (but not encrypting) additionalData,
+// which must be passed unchanged to `OpenWithData`
+func SealWithData(aead cipher.AEAD, random io.Reader, dst []byte, plaintext []byte, additionalData []byte) (ciphertext []byte, err error) {
+	// Read a true random nonce
+	ciphertext = append(dst, make([]byte, NonceSize)...)
+	nonce := ciphertext[len(dst):]
+	if _, err = io.ReadFull(random, nonce); err != nil {
+		return nil, err
+	}
+
+	// Encrypt data
+	return aead.Seal(ciphertext, nonce, plaintext, additionalData), nil
+}
+
+// Returns the AEAD cipher (AES GCM) for a key
+func NewAEAD(key [KeySize]byte) cipher.AEAD {
 	// Create cipher block
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
 		panic(err) // The key must be the right size (unless we got a wrong KeySize)
 	}
 
-	// Create a true random nonce
-	nonce := make([]byte, NonceSize)
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return
-	}
-
 	// Setup the AEAD cipher (AES GCM)
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
 		panic(err) // As long as we use a standard NonceSize we should never hit this error
 	}
-
-	// Encrypt data
-	ciphertext = aesgcm.Seal(nonce, nonce, plaintext, nil)
-
-	return
+	return aesgcm
 }
 
 // Decrypt some plaintext data with a key
 func Decrypt(key [KeySize]byte, ciphertext []byte) (plaintext []byte, err error) {
+	return Open(NewAEAD(key), nil, ciphertext)
+}
+
+// Decrypts a ciphertext from `Seal` with aead, appending the
+// plaintext to dst
+func Open(aead cipher.AEAD, dst []byte, ciphertext []byte) (plaintext []byte, err error) {
+	return OpenWithData(aead, dst, ciphertext, nil)
+}
+
+// Like `Open`, for a ciphertext from `SealWithData` with the same
+// additionalData
+func OpenWithData(aead cipher.AEAD, dst []byte, ciphertext []byte, additionalData []byte) (plaintext []byte, err error) {
 	// Check the length of the ciphertext
 	if len(ciphertext) < NonceSize {
 		err = ErrInvalidCiphertextSize
 		return
 	}
 
-	// Create cipher block
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		panic(err) // The key must be the right size (unless we got a wrong KeySize)
-	}
-
-	// Get the 16-bit nonce
+	// Get the 12-byte nonce
 	nonce := ciphertext[0:NonceSize]
 	ciphertext = ciphertext[NonceSize:]
 
-	// Setup the AEAD cipher (AES GCM)
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		panic(err) // As long as we use a standard NonceSize we should never hit this error
-	}
-
 	// Decrypt data
-	plaintext, err = aesgcm.Open(nil, nonce, ciphertext, nil)
-
-	return
+	return aead.Open(dst, nonce, ciphertext, additionalData)
 }
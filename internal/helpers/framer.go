@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+var (
+	// Error returned when a frame is too long for the length prefix
+	// of a `Framer`
+	ErrFrameLength = errors.New("frame is too long for the length prefix")
+	// Error returned when a uvarint length prefix is malformed
+	ErrFrameUvarint = errors.New("frame has an invalid uvarint length prefix")
+)
+
+var (
+	// Prefixes frames with their length as an unsigned varint
+	// (1 byte for frames under 128 bytes, up to 10 bytes)
+	FramerUvarint Framer = uvarintFramer{}
+	// Prefixes frames with their length as a big-endian uint16
+	// (frames up to 64 KiB)
+	FramerUint16 Framer = fixedFramer{size: 2}
+	// Prefixes frames with their length as a big-endian uint32
+	// (frames up to 4 GiB)
+	FramerUint32 Framer = fixedFramer{size: 4}
+	// Prefixes frames with their length as a big-endian uint64
+	// (the format of `Encode`)
+	FramerUint64 Framer = fixedFramer{size: BytesFor64Bit}
+)
+
+// Writes and reads the length prefix of frames
+type Framer interface {
+	// Appends the prefix for a frame of length bytes to dst, or
+	// returns an error wrapping `ErrFrameLength` if the length
+	// doesn't fit
+	AppendLength(dst []byte, length uint64) ([]byte, error)
+	// Reads a length prefix from reader
+	ReadLength(reader io.Reader) (length uint64, err error)
+	// Returns the largest frame length the prefix can hold
+	MaxLength() uint64
+}
+
+var (
+	// The buffers the length prefixes are read into (passing a
+	// buffer on the stack to a reader would allocate it)
+	lengthBufferPool = sync.Pool{New: func() any { return new([BytesFor64Bit]byte) }}
+)
+
+// A length prefix as an unsigned varint
+type uvarintFramer struct{}
+
+// A length prefix as a big-endian unsigned integer of a fixed size
+type fixedFramer struct {
+	// The size of the prefix (2, 4 or 8 bytes)
+	size int
+}
+
+// Appends the uvarint length to dst
+func (uvarintFramer) AppendLength(dst []byte, length uint64) ([]byte, error) {
+	return binary.AppendUvarint(dst, length), nil
+}
+
+// Reads a uvarint length, one byte at a time so that nothing past
+// the prefix is consumed
+func (uvarintFramer) ReadLength(reader io.Reader) (length uint64, err error) {
+	buffer := lengthBufferPool.Get().(*[BytesFor64Bit]byte)
+	defer lengthBufferPool.Put(buffer)
+	b := buffer[:1]
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if _, err = io.ReadFull(reader, b); err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		if i == binary.MaxVarintLen64-1 && b[0] > 1 {
+			break // Overflows 64 bits
+		}
+		length |= uint64(b[0]&0x7f) << (7 * i)
+		if b[0] < 0x80 {
+			return
+		}
+	}
+	return 0, ErrFrameUvarint
+}
+
+// Any length fits in a uvarint
+func (uvarintFramer) MaxLength() uint64 {
+	return math.MaxUint64
+}
+
+// Appends the big-endian length to dst
+func (framer fixedFramer) AppendLength(dst []byte, length uint64) ([]byte, error) {
+	if length > framer.MaxLength() {
+		return dst, fmt.Errorf("%w (%d > %d bytes)", ErrFrameLength, length, framer.MaxLength())
+	}
+	var buffer [BytesFor64Bit]byte
+	binary.BigEndian.PutUint64(buffer[:], length)
+	return append(dst, buffer[BytesFor64Bit-framer.size:]...), nil
+}
+
+// Reads a big-endian length
+func (framer fixedFramer) ReadLength(reader io.Reader) (length uint64, err error) {
+	buffer := lengthBufferPool.Get().(*[BytesFor64Bit]byte)
+	defer lengthBufferPool.Put(buffer)
+	clear(buffer[:])
+	if _, err = io.ReadFull(reader, buffer[BytesFor64Bit-framer.size:]); err != nil {
+		return
+	}
+	return binary.BigEndian.Uint64(buffer[:]), nil
+}
+
+// Returns the largest value of the prefix
+func (framer fixedFramer) MaxLength() uint64 {
+	return math.MaxUint64 >> (64 - 8*framer.size)
+}
+
+// Writes a frame (the length prefix from framer, then data) into
+// a writer with a single Write call
+func EncodeFrame(writer io.Writer, framer Framer, data []byte) (err error) {
+	return NewEncoder(writer, framer).Encode(data)
+}
@@ -2,8 +2,12 @@ package helpers
 
 import (
 	"crypto/sha256"
+	"io"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
 )
 
 // Derives a 32 byte key from a password using SHA256
@@ -13,7 +17,7 @@ func DeriveKey(password string) [KeySize]byte {
 
 // Derives a 32 byte key from a password using Argon2id
 func DeriveKeySecure(password string, salt []byte, time uint32, memory uint32, parallelism uint8) (derivedKey [KeySize]byte) {
-	keyBytes := argon2.IDKey([]byte(password), salt, time, memory, parallelism, KeySize)
+	keyBytes := DeriveBytesSecure([]byte(password), salt, time, memory, parallelism, KeySize)
 	if len(keyBytes) != KeySize {
 		// argon2.Key promises us a key of the expected size, we check that promise here
 		panic("derived key doesn't match expected key size")
@@ -21,3 +25,30 @@ func DeriveKeySecure(password string, salt []byte, time uint32, memory uint32, p
 	copy(derivedKey[:], keyBytes[:KeySize])
 	return
 }
+
+// Derives a key of the given length from a password using Argon2id
+func DeriveBytesSecure(password []byte, salt []byte, time uint32, memory uint32, parallelism uint8, length uint32) []byte {
+	return argon2.IDKey(password, salt, time, memory, parallelism, length)
+}
+
+// Derives a key of the given length from a password using scrypt
+// with N = 2^logCost
+func DeriveBytesScrypt(password []byte, salt []byte, logCost uint8, blockSize int, parallelism int, length int) ([]byte, error) {
+	return scrypt.Key(password, salt, 1<<logCost, blockSize, parallelism, length)
+}
+
+// Derives a key of the given length from a password using PBKDF2
+// with HMAC-SHA256
+func DeriveBytesPBKDF2(password []byte, salt []byte, iterations int, length int) []byte {
+	return pbkdf2.Key(password, salt, iterations, length, sha256.New)
+}
+
+// Derives a key of the given length from a high-entropy secret using
+// HKDF with SHA256
+func DeriveBytesHKDF(secret []byte, salt []byte, info []byte, length int) (derived []byte, err error) {
+	derived = make([]byte, length)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, info), derived); err != nil {
+		derived = nil
+	}
+	return
+}
@@ -85,6 +85,65 @@ func TestDeriveSecureKey(t *testing.T) {
 	}
 }
 
+func TestDeriveBytesSecure(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, testCase := range argon2idTestCases {
+		derived := helpers.DeriveBytesSecure([]byte(testCase.password), testCase.salt, testCase.time, testCase.memory, testCase.parallelism, helpers.KeySize)
+		if !slices.Equal(derived, testCase.derivedKey[:]) {
+			t.Fatalf("derived bytes for %q (%x) don't match expected (%x)", testCase.password, derived, testCase.derivedKey)
+		}
+		// A shorter output is not a prefix of the longer one
+		short := helpers.DeriveBytesSecure([]byte(testCase.password), testCase.salt, testCase.time, testCase.memory, testCase.parallelism, 16)
+		if len(short) != 16 || slices.Equal(short, derived[:16]) {
+			t.Fatalf("unexpected 16 byte output for %q: %x", testCase.password, short)
+		}
+	}
+}
+
+func TestDeriveBytesScrypt(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// RFC 7914, section 12
+	derived, err := helpers.DeriveBytesScrypt([]byte("password"), []byte("NaCl"), 10, 8, 16, 64)
+	if err != nil {
+		t.Fatalf("failed to derive scrypt key: %v", err)
+	}
+	expected := hexDecode("fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640")
+	if !slices.Equal(derived, expected) {
+		t.Fatalf("scrypt derived %x instead of %x", derived, expected)
+	}
+	// Invalid parameters
+	if _, err = helpers.DeriveBytesScrypt([]byte("password"), []byte("NaCl"), 0, 8, 16, 64); err == nil {
+		t.Fatalf("expected an error with N = 1")
+	}
+}
+
+func TestDeriveBytesPBKDF2(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// RFC 7914, section 11
+	derived := helpers.DeriveBytesPBKDF2([]byte("passwd"), []byte("salt"), 1, 64)
+	expected := hexDecode("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	if !slices.Equal(derived, expected) {
+		t.Fatalf("pbkdf2 derived %x instead of %x", derived, expected)
+	}
+}
+
+func TestDeriveBytesHKDF(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// RFC 5869, test case 1
+	derived, err := helpers.DeriveBytesHKDF(hexDecode("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"), hexDecode("000102030405060708090a0b0c"), hexDecode("f0f1f2f3f4f5f6f7f8f9"), 42)
+	if err != nil {
+		t.Fatalf("failed to derive hkdf key: %v", err)
+	}
+	expected := hexDecode("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+	if !slices.Equal(derived, expected) {
+		t.Fatalf("hkdf derived %x instead of %x", derived, expected)
+	}
+	// HKDF-SHA256 can't output more than 255 * 32 bytes
+	if _, err = helpers.DeriveBytesHKDF([]byte("secret"), nil, nil, 255*32+1); err == nil {
+		t.Fatalf("expected an error with an output that is too long")
+	}
+}
+
 func FuzzDeriveKey(f *testing.F) {
 	for _, testCase := range deriveKeyTestCases {
 		f.Add(testCase.key)
@@ -4,28 +4,221 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 )
 
 const (
 	// The number of bytes we require to store a 64-bit value
 	BytesFor64Bit = 64 / 8
+	// The RFC 4648 base32 alphabet
+	AlphabetBase32 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	// The base58 alphabet used by Bitcoin (no 0, O, I and l)
+	AlphabetBase58 = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	// Digits, upper case and lower case letters
+	AlphabetAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// The size of a UUID
+	UUIDSize = 16
 )
 
-// Returns a true-random hex string of a given length
-func RandomHex(length int) string {
+var (
+	// Error returned when a random value is requested with a
+	// negative length
+	ErrRandomLength = errors.New("random: negative length")
+	// Error returned when a random number is requested in an
+	// empty range
+	ErrRandomRange = errors.New("random: the upper bound must be positive")
+	// Error returned when a random string is requested with an
+	// empty alphabet
+	ErrRandomAlphabet = errors.New("random: empty alphabet")
+)
+
+// Returns length true-random bytes, or an error if the length is
+// negative or the system's random source fails
+func ReadRandomBytes(length int) (randomBytes []byte, err error) {
+	if length < 0 {
+		return nil, ErrRandomLength
+	}
+	randomBytes = make([]byte, length)
+	if err = readRandom(randomBytes); err != nil {
+		randomBytes = nil
+	}
+	return
+}
+
+// Returns a true-random hex string of a given length, or an error
+// if the length is negative or the system's random source fails
+func ReadRandomHex(length int) (randomHex string, err error) {
+	if length < 0 {
+		return "", ErrRandomLength
+	}
 	randomBytes := make([]byte, (length+1)/2)
-	if _, err := rand.Read(randomBytes); err != nil {
-		panic(fmt.Errorf("RandomBase32 failed to read %d random bytes %w", (length+1)/2, err))
+	if err = readRandom(randomBytes); err != nil {
+		return
 	}
-	return hex.EncodeToString(randomBytes)[:length]
+	return hex.EncodeToString(randomBytes)[:length], nil
 }
 
-// Returns a true-random uint64 number
-func RandomUint64() uint64 {
+// Returns a true-random uint64 number, or an error if the system's
+// random source fails
+func ReadRandomUint64() (value uint64, err error) {
+	return ReadRandomUint64From(rand.Reader)
+}
+
+// Returns a uint64 number read from random, or an error if random
+// fails
+func ReadRandomUint64From(random io.Reader) (value uint64, err error) {
 	var b [BytesFor64Bit]byte
-	if _, err := rand.Read(b[:]); err != nil {
-		panic(fmt.Errorf("RandomUint64 failed to read %d random bytes %w", BytesFor64Bit, err))
+	if err = readRandomFrom(random, b[:]); err != nil {
+		return
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// Returns a true-random number in [0, n) without modulo bias, or an
+// error if n <= 0 or the system's random source fails
+func ReadRandomIntn(n int) (value int, err error) {
+	if n <= 0 {
+		return 0, ErrRandomRange
+	}
+	random, err := readRandomUint64n(uint64(n))
+	return int(random), err
+}
+
+// Returns a true-random string of length characters picked from
+// alphabet (which can contain any unicode character), or an error if
+// the length is negative, the alphabet is empty or the system's
+// random source fails
+func ReadRandomString(length int, alphabet string) (random string, err error) {
+	if length < 0 {
+		return "", ErrRandomLength
+	}
+	characters := []rune(alphabet)
+	if len(characters) == 0 {
+		return "", ErrRandomAlphabet
+	}
+	result := make([]rune, length)
+	for i := range result {
+		index, err := readRandomUint64n(uint64(len(characters)))
+		if err != nil {
+			return "", err
+		}
+		result[i] = characters[index]
+	}
+	return string(result), nil
+}
+
+// Returns a random (version 4) UUID as defined by RFC 9562, or an
+// error if the system's random source fails
+func ReadRandomUUIDv4() (uuid [UUIDSize]byte, err error) {
+	if err = readRandom(uuid[:]); err != nil {
+		return
+	}
+	setUUIDVersion(&uuid, 4)
+	return
+}
+
+// Returns a time-ordered (version 7) UUID for the given time as
+// defined by RFC 9562: the first 48 bits are the milliseconds since
+// the unix epoch and the other bits are random. Returns an error if
+// the system's random source fails.
+func ReadRandomUUIDv7(now time.Time) (uuid [UUIDSize]byte, err error) {
+	if err = readRandom(uuid[6:]); err != nil {
+		return
+	}
+	milliseconds := uint64(now.UnixMilli())
+	binary.BigEndian.PutUint16(uuid[0:2], uint16(milliseconds>>32))
+	binary.BigEndian.PutUint32(uuid[2:6], uint32(milliseconds))
+	setUUIDVersion(&uuid, 7)
+	return
+}
+
+// Returns length true-random bytes.
+// Panics on failure (see `ReadRandomBytes`).
+func RandomBytes(length int) []byte {
+	return must(ReadRandomBytes(length))
+}
+
+// Returns a true-random hex string of a given length.
+// Panics on failure (see `ReadRandomHex`).
+func RandomHex(length int) string {
+	return must(ReadRandomHex(length))
+}
+
+// Returns a true-random uint64 number.
+// Panics on failure (see `ReadRandomUint64`).
+func RandomUint64() uint64 {
+	return must(ReadRandomUint64())
+}
+
+// Returns a true-random number in [0, n) without modulo bias.
+// Panics on failure, including if n <= 0 (see `ReadRandomIntn`).
+func RandomIntn(n int) int {
+	return must(ReadRandomIntn(n))
+}
+
+// Returns a true-random string of length characters picked from
+// alphabet (which can contain any unicode character).
+// Panics on failure, including if the alphabet is empty (see
+// `ReadRandomString`).
+func RandomString(length int, alphabet string) string {
+	return must(ReadRandomString(length, alphabet))
+}
+
+// Returns a random (version 4) UUID as defined by RFC 9562.
+// Panics on failure (see `ReadRandomUUIDv4`).
+func RandomUUIDv4() [UUIDSize]byte {
+	return must(ReadRandomUUIDv4())
+}
+
+// Returns a time-ordered (version 7) UUID for the given time.
+// Panics on failure (see `ReadRandomUUIDv7`).
+func RandomUUIDv7(now time.Time) [UUIDSize]byte {
+	return must(ReadRandomUUIDv7(now))
+}
+
+// Fills b with true-random bytes
+func readRandom(b []byte) (err error) {
+	return readRandomFrom(rand.Reader, b)
+}
+
+// Fills b with bytes from random
+func readRandomFrom(random io.Reader, b []byte) (err error) {
+	if _, err = io.ReadFull(random, b); err != nil {
+		err = fmt.Errorf("random: failed to read %d random bytes: %w", len(b), err)
+	}
+	return
+}
+
+// Returns a true-random uint64 in [0, n) for n > 0, rejecting the
+// values that would make some results more likely than others
+func readRandomUint64n(n uint64) (uint64, error) {
+	// 2^64 mod n: values below this are rejected, leaving a range
+	// that is a multiple of n
+	threshold := -n % n
+	for {
+		value, err := ReadRandomUint64()
+		if err != nil {
+			return 0, err
+		}
+		if value >= threshold {
+			return value % n, nil
+		}
+	}
+}
+
+// Sets the version and the variant bits of a UUID
+func setUUIDVersion(uuid *[UUIDSize]byte, version byte) {
+	uuid[6] = uuid[6]&0x0f | version<<4
+	uuid[8] = uuid[8]&0x3f | 0x80
+}
+
+// Returns value, panicking if err is not nil
+func must[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
 	}
-	return binary.LittleEndian.Uint64(b[:])
+	return value
 }
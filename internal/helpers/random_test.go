@@ -1,9 +1,12 @@
 package helpers_test
 
 import (
+	"bytes"
 	"fmt"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
 )
@@ -89,3 +92,140 @@ func TestRandomHexParallelism(t *testing.T) {
 		}
 	}
 }
+
+func TestRandomBytes(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, length := range []int{0, 1, 16, 1000} {
+		if randomBytes := helpers.RandomBytes(length); len(randomBytes) != length {
+			t.Fatalf("helpers.RandomBytes(%d) returned %d bytes", length, len(randomBytes))
+		}
+	}
+	if bytes.Equal(helpers.RandomBytes(32), helpers.RandomBytes(32)) {
+		t.Fatalf("helpers.RandomBytes returned the same bytes twice")
+	}
+}
+
+func TestRandomIntn(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Every value is in range and about as likely as the others
+	const n, samples = 3, 30000
+	counts := make([]int, n)
+	for i := 0; i < samples; i++ {
+		value := helpers.RandomIntn(n)
+		if value < 0 || value >= n {
+			t.Fatalf("helpers.RandomIntn(%d) returned %d", n, value)
+		}
+		counts[value]++
+	}
+	for value, count := range counts {
+		if count < samples/n*9/10 || count > samples/n*11/10 {
+			t.Fatalf("helpers.RandomIntn(%d) returned %d %d times out of %d", n, value, count, samples)
+		}
+	}
+	if value := helpers.RandomIntn(1); value != 0 {
+		t.Fatalf("helpers.RandomIntn(1) returned %d", value)
+	}
+	for _, invalid := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("helpers.RandomIntn(%d) didn't panic", invalid)
+				}
+			}()
+			helpers.RandomIntn(invalid)
+		}()
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, alphabet := range []string{helpers.AlphabetBase32, helpers.AlphabetBase58, helpers.AlphabetAlphanumeric, "ab", "αβγ"} {
+		random := helpers.RandomString(100, alphabet)
+		if runes := []rune(random); len(runes) != 100 {
+			t.Fatalf("helpers.RandomString(100, %q) returned %d characters", alphabet, len(runes))
+		}
+		for _, r := range random {
+			if !strings.ContainsRune(alphabet, r) {
+				t.Fatalf("helpers.RandomString(100, %q) returned %q", alphabet, random)
+			}
+		}
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("helpers.RandomString with an empty alphabet didn't panic")
+			}
+		}()
+		helpers.RandomString(1, "")
+	}()
+}
+
+func TestRandomUUID(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	uuid := helpers.RandomUUIDv4()
+	if uuid[6]>>4 != 4 || uuid[8]>>6 != 2 {
+		t.Fatalf("unexpected version or variant in UUIDv4 %x", uuid)
+	}
+	if uuid == helpers.RandomUUIDv4() {
+		t.Fatalf("helpers.RandomUUIDv4 returned %x twice", uuid)
+	}
+	// The example time from RFC 9562 (Appendix A.6)
+	now := time.UnixMilli(0x017F22E279B0)
+	uuid = helpers.RandomUUIDv7(now)
+	if uuid[6]>>4 != 7 || uuid[8]>>6 != 2 {
+		t.Fatalf("unexpected version or variant in UUIDv7 %x", uuid)
+	}
+	if !bytes.Equal(uuid[:6], []byte{0x01, 0x7f, 0x22, 0xe2, 0x79, 0xb0}) {
+		t.Fatalf("unexpected timestamp in UUIDv7 %x", uuid)
+	}
+	// Later UUIDs sort after earlier ones
+	later := helpers.RandomUUIDv7(now.Add(time.Millisecond))
+	if bytes.Compare(later[:], uuid[:]) <= 0 {
+		t.Fatalf("expected %x to sort after %x", later, uuid)
+	}
+}
+
+func TestReadRandom(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Invalid inputs
+	if _, err := helpers.ReadRandomBytes(-1); err != helpers.ErrRandomLength {
+		t.Fatalf("expected ErrRandomLength, instead got %v", err)
+	}
+	if _, err := helpers.ReadRandomHex(-1); err != helpers.ErrRandomLength {
+		t.Fatalf("expected ErrRandomLength, instead got %v", err)
+	}
+	if _, err := helpers.ReadRandomString(-1, "ab"); err != helpers.ErrRandomLength {
+		t.Fatalf("expected ErrRandomLength, instead got %v", err)
+	}
+	if _, err := helpers.ReadRandomString(1, ""); err != helpers.ErrRandomAlphabet {
+		t.Fatalf("expected ErrRandomAlphabet, instead got %v", err)
+	}
+	for _, n := range []int{0, -1} {
+		if _, err := helpers.ReadRandomIntn(n); err != helpers.ErrRandomRange {
+			t.Fatalf("expected ErrRandomRange for %d, instead got %v", n, err)
+		}
+	}
+	// Valid inputs
+	if randomBytes, err := helpers.ReadRandomBytes(10); err != nil || len(randomBytes) != 10 {
+		t.Fatalf("unexpected result %x (%v)", randomBytes, err)
+	}
+	if randomHex, err := helpers.ReadRandomHex(7); err != nil || len(randomHex) != 7 {
+		t.Fatalf("unexpected result %q (%v)", randomHex, err)
+	}
+	if random, err := helpers.ReadRandomString(5, "ab"); err != nil || len(random) != 5 {
+		t.Fatalf("unexpected result %q (%v)", random, err)
+	}
+	if value, err := helpers.ReadRandomIntn(10); err != nil || value < 0 || value >= 10 {
+		t.Fatalf("unexpected result %d (%v)", value, err)
+	}
+	if _, err := helpers.ReadRandomUint64(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// The panicking variants report the same errors
+	defer func() {
+		if err, _ := recover().(error); err != helpers.ErrRandomLength {
+			t.Fatalf("expected a panic with ErrRandomLength, instead got %v", err)
+		}
+	}()
+	helpers.RandomHex(-1)
+}
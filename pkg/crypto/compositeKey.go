@@ -0,0 +1,195 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+const (
+	// The size of the key files created by `NewCompositeKeyFile`
+	CompositeKeyFileSize = 64
+)
+
+var (
+	// Error returned when deriving a `CompositeKey` without factors
+	ErrNoKeyFactors = errors.New("composite key: no factors")
+	// Error returned by `NewCompositeKeyFile` if the file exists
+	ErrCompositeKeyFileExists = errors.New("composite key: the key file already exists")
+)
+
+// The kinds of factors, hashed with each factor so that the same
+// bytes used as different factors give different keys
+const (
+	compositeFactorPassword byte = 1
+	compositeFactorKeyFile  byte = 2
+	compositeFactorSecret   byte = 3
+)
+
+var (
+	// Prefixes every hash computed for a composite key
+	compositeKeyDomain = []byte("GoSymCrypto composite key v1\x00")
+)
+
+// A key made of several factors, such as a password and the
+// contents of a key file, all of which are needed to derive it.
+//
+// Each factor is hashed with SHA256 as soon as it is added (the
+// factors themselves are not kept) and the combined hash is used as
+// the password for the key derivation, so a strong factor such as a
+// key file makes the key strong even if the password is weak, and
+// the password alone is useless without the key file.
+//
+// Factors must be added in the same order every time. A
+// CompositeKey is not safe for concurrent use.
+type CompositeKey struct {
+	// The hash of each factor, in order
+	factors [][sha256.Size]byte
+	// Whether a password is one of the factors
+	hasPassword bool
+}
+
+// Creates an empty `CompositeKey`
+func NewCompositeKey() *CompositeKey {
+	return &CompositeKey{}
+}
+
+// Adds a password factor
+func (composite *CompositeKey) AddPassword(password string) {
+	composite.addFactor(compositeFactorPassword, []byte(password))
+	composite.hasPassword = true
+}
+
+// Adds a key file factor from the contents of the file at path,
+// which can be any file that doesn't change (see
+// `NewCompositeKeyFile`)
+func (composite *CompositeKey) AddKeyFile(path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	factor := newCompositeHash(compositeFactorKeyFile)
+	if _, err = io.Copy(factor, file); err != nil {
+		return
+	}
+	composite.factors = append(composite.factors, [sha256.Size]byte(factor.Sum(nil)))
+	return
+}
+
+// Adds a key file factor from the contents of a key file
+func (composite *CompositeKey) AddKeyFileData(data []byte) {
+	composite.addFactor(compositeFactorKeyFile, data)
+}
+
+// Adds a secret factor, such as a value from a hardware token or a
+// second secret stored elsewhere
+func (composite *CompositeKey) AddSecret(secret []byte) {
+	composite.addFactor(compositeFactorSecret, secret)
+}
+
+// Returns the number of factors added so far
+func (composite *CompositeKey) Len() int {
+	return len(composite.factors)
+}
+
+// Derives a key from all the factors as described by params.
+//
+// The params must be valid and have a `KeyLength` matching the size
+// of an `AESKey`. If one of the factors is a password, they must
+// also be meant for passwords (see `ErrKDFNotForPasswords`).
+func (composite *CompositeKey) DeriveKey(params KDFParams) (key AESKey, err error) {
+	if len(composite.factors) == 0 {
+		err = ErrNoKeyFactors
+		return
+	}
+	if composite.hasPassword {
+		err = params.validateForPasswords()
+	} else {
+		err = params.Validate()
+	}
+	if err != nil {
+		return
+	}
+	if params.KeyLength != helpers.KeySize {
+		err = ErrKDFKeyLength
+		return
+	}
+	combined := composite.combine()
+	derived, err := deriveBytes(combined[:], params)
+	clear(combined[:])
+	if err != nil {
+		return
+	}
+	copy(key[:], derived)
+	clear(derived)
+	return
+}
+
+// Derives a key from all the factors using Argon2id, with the
+// defaults of `DeriveSecureKey` for time, memory, and threads
+// equal to `0`.
+//
+// Unlike `DeriveSecureKey`, a salt is required.
+func (composite *CompositeKey) DeriveSecureKey(salt []byte, time uint32, memory uint32, threads uint8) (key AESKey, err error) {
+	if err = checkSalt(salt); err != nil {
+		return
+	}
+	return composite.DeriveKey(SecureKeyParams(salt, time, memory, threads))
+}
+
+// Removes all the factors, overwriting their hashes
+func (composite *CompositeKey) Clear() {
+	for i := range composite.factors {
+		clear(composite.factors[i][:])
+	}
+	composite.factors = nil
+	composite.hasPassword = false
+}
+
+// Creates a new key file at path with `CompositeKeyFileSize` random
+// bytes, for use with `CompositeKey.AddKeyFile`. It never replaces
+// an existing file.
+func NewCompositeKeyFile(path string) (err error) {
+	data := make([]byte, CompositeKeyFileSize)
+	if _, err = io.ReadFull(rand.Reader, data); err != nil {
+		return
+	}
+	created, err := writeFileExclusive(path, data, KeyFilePermissions)
+	clear(data)
+	if err == nil && !created {
+		err = ErrCompositeKeyFileExists
+	}
+	return
+}
+
+// Hashes a factor and adds it
+func (composite *CompositeKey) addFactor(kind byte, data []byte) {
+	factor := newCompositeHash(kind)
+	factor.Write(data)
+	composite.factors = append(composite.factors, [sha256.Size]byte(factor.Sum(nil)))
+}
+
+// Returns the hash of all the factors
+func (composite *CompositeKey) combine() (combined [sha256.Size]byte) {
+	combiner := sha256.New()
+	combiner.Write(compositeKeyDomain)
+	for _, factor := range composite.factors {
+		combiner.Write(factor[:])
+	}
+	combiner.Sum(combined[:0])
+	return
+}
+
+// Returns a SHA256 hash prefixed with the domain and the kind of factor
+func newCompositeHash(kind byte) hash.Hash {
+	factor := sha256.New()
+	factor.Write(compositeKeyDomain)
+	factor.Write([]byte{kind})
+	return factor
+}
@@ -0,0 +1,116 @@
+package crypto_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestCompositeKey(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	params := crypto.KDFParams{
+		Algorithm: crypto.KDFPBKDF2SHA256,
+		Salt:      []byte("somesalt"),
+		Time:      1000,
+		KeyLength: 32,
+	}
+	// Computed independently from the description of the format
+	composite := crypto.NewCompositeKey()
+	composite.AddPassword("password")
+	composite.AddKeyFileData([]byte("key file contents"))
+	key, err := composite.DeriveKey(params)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if expected := "149be1e7fa0b9d5c72f34e17771ccb886bb5550e4acdee15fb3e19f18ccac141"; hex.EncodeToString(key[:]) != expected {
+		t.Fatalf("expected %s, instead got %x", expected, key)
+	}
+	// Reading the key file gives the same key
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("key file contents"), 0o600); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+	fromFile := crypto.NewCompositeKey()
+	fromFile.AddPassword("password")
+	if err := fromFile.AddKeyFile(path); err != nil {
+		t.Fatalf("failed to add key file: %v", err)
+	}
+	if other, err := fromFile.DeriveKey(params); err != nil || other != key {
+		t.Fatalf("expected %x, instead got %x (%v)", key, other, err)
+	}
+	// Every factor, its kind and the order matter
+	for name, factors := range map[string]func(*crypto.CompositeKey){
+		"password only": func(c *crypto.CompositeKey) { c.AddPassword("password") },
+		"key file only": func(c *crypto.CompositeKey) { c.AddKeyFileData([]byte("key file contents")) },
+		"swapped": func(c *crypto.CompositeKey) {
+			c.AddKeyFileData([]byte("key file contents"))
+			c.AddPassword("password")
+		},
+		"secret instead of key file": func(c *crypto.CompositeKey) {
+			c.AddPassword("password")
+			c.AddSecret([]byte("key file contents"))
+		},
+		"extra secret": func(c *crypto.CompositeKey) {
+			c.AddPassword("password")
+			c.AddKeyFileData([]byte("key file contents"))
+			c.AddSecret([]byte("token"))
+		},
+	} {
+		other := crypto.NewCompositeKey()
+		factors(other)
+		if otherKey, err := other.DeriveKey(params); err != nil || otherKey == key {
+			t.Fatalf("%s: expected a different key (%v)", name, err)
+		}
+	}
+	// Errors
+	if err := fromFile.AddKeyFile(filepath.Join(t.TempDir(), "missing")); err == nil || fromFile.Len() != 2 {
+		t.Fatalf("expected an error adding a missing key file, instead got %v with %d factors", err, fromFile.Len())
+	}
+	if _, err := fromFile.DeriveSecureKey(nil, 1, 64, 1); !errors.Is(err, crypto.ErrMissingSalt) {
+		t.Fatalf("expected ErrMissingSalt, instead got %v", err)
+	}
+	if _, err := fromFile.DeriveSecureKey([]byte("somesalt"), 1, 64, 1); err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	hkdfParams := crypto.KDFParams{Algorithm: crypto.KDFHKDFSHA256, Salt: []byte("somesalt"), KeyLength: 32}
+	if _, err := fromFile.DeriveKey(hkdfParams); !errors.Is(err, crypto.ErrKDFNotForPasswords) {
+		t.Fatalf("expected ErrKDFNotForPasswords, instead got %v", err)
+	}
+	passwordOnly := crypto.NewCompositeKey()
+	passwordOnly.AddPassword("password")
+	if _, err := passwordOnly.DeriveKey(hkdfParams); !errors.Is(err, crypto.ErrKDFNotForPasswords) {
+		t.Fatalf("expected ErrKDFNotForPasswords, instead got %v", err)
+	}
+	// Without a password, HKDF is enough
+	keyFileOnly := crypto.NewCompositeKey()
+	keyFileOnly.AddKeyFileData([]byte("key file contents"))
+	if _, err := keyFileOnly.DeriveKey(hkdfParams); err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	fromFile.Clear()
+	if _, err := fromFile.DeriveKey(params); !errors.Is(err, crypto.ErrNoKeyFactors) {
+		t.Fatalf("expected ErrNoKeyFactors, instead got %v", err)
+	}
+}
+
+func TestNewCompositeKeyFile(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	path := filepath.Join(t.TempDir(), "key")
+	if err := crypto.NewCompositeKeyFile(path); err != nil {
+		t.Fatalf("failed to create key file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) != crypto.CompositeKeyFileSize {
+		t.Fatalf("unexpected key file contents %x (%v)", data, err)
+	}
+	if err := crypto.NewCompositeKeyFile(path); !errors.Is(err, crypto.ErrCompositeKeyFileExists) {
+		t.Fatalf("expected ErrCompositeKeyFileExists, instead got %v", err)
+	}
+	if again, err := os.ReadFile(path); err != nil || string(again) != string(data) {
+		t.Fatalf("the key file was modified (%v)", err)
+	}
+}
@@ -0,0 +1,261 @@
+package crypto
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// Error returned by `NewDerivedKeyCache` for a non-positive size
+	ErrDerivedKeyCacheSize = errors.New("derived key cache: the size must be at least 1")
+	// Error returned by `DerivedKeyCache.DeriveKey` (to the caller
+	// and to the concurrent calls waiting for the key) when the key
+	// derivation panics
+	ErrDerivationPanicked = errors.New("derived key cache: the key derivation panicked")
+)
+
+// A cache of derived keys, so that deriving the same key again
+// (same password, salt and parameters) doesn't pay for the key
+// derivation again.
+//
+// Entries are identified by an HMAC of the password and parameters
+// under a random key private to the cache; passwords are never
+// stored. The cache keeps at most a fixed number of keys, evicting
+// the least recently used first, and keys expire after a fixed time.
+// Evicted and expired keys are overwritten. Concurrent calls for the
+// same entry only derive the key once.
+//
+// A DerivedKeyCache is safe for concurrent use.
+type DerivedKeyCache struct {
+	// The key used to compute the identifiers of the entries
+	hmacKey [sha256.Size]byte
+	// The maximum number of keys kept
+	maxEntries int
+	// How long a key is kept after being derived (0 for no limit)
+	ttl time.Duration
+
+	// Guards the fields below
+	lock sync.Mutex
+	// The entries by identifier
+	entries map[[sha256.Size]byte]*list.Element
+	// The entries (*derivedKeyEntry), most recently used first
+	order list.List
+	// The derivations in progress by identifier
+	calls map[[sha256.Size]byte]*derivedKeyCall
+	// The counters reported by `Stats`
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// A snapshot of the counters of a `DerivedKeyCache`
+type DerivedKeyCacheStats struct {
+	// The number of keys in the cache
+	Entries int
+	// The number of keys returned from the cache (including
+	// derivations shared with a concurrent call)
+	Hits uint64
+	// The number of keys derived
+	Misses uint64
+	// The number of keys removed because the cache was full or
+	// they expired
+	Evictions uint64
+}
+
+// A key in the cache
+type derivedKeyEntry struct {
+	// The identifier of the entry
+	id [sha256.Size]byte
+	// The derived key
+	key AESKey
+	// When the key expires (zero for never)
+	expires time.Time
+}
+
+// A derivation in progress
+type derivedKeyCall struct {
+	// Closed once key and err are set
+	done chan struct{}
+	// The result of the derivation
+	key AESKey
+	err error
+}
+
+// Creates a `DerivedKeyCache` keeping at most maxEntries keys, each
+// for at most ttl after it was derived (0 to keep keys until they
+// are evicted)
+func NewDerivedKeyCache(maxEntries int, ttl time.Duration) (cache *DerivedKeyCache, err error) {
+	if maxEntries < 1 {
+		err = ErrDerivedKeyCacheSize
+		return
+	}
+	cache = &DerivedKeyCache{
+		maxEntries: maxEntries,
+		ttl:        max(ttl, 0),
+		entries:    map[[sha256.Size]byte]*list.Element{},
+		calls:      map[[sha256.Size]byte]*derivedKeyCall{},
+	}
+	if _, err = io.ReadFull(rand.Reader, cache.hmacKey[:]); err != nil {
+		cache = nil
+	}
+	return
+}
+
+// Derives a key from a password as described by params (like
+// `DeriveKeyWithParams`), or returns it from the cache.
+//
+// Errors are not cached. A panic of the derivation is returned as an
+// error wrapping `ErrDerivationPanicked`.
+func (cache *DerivedKeyCache) DeriveKey(password string, params KDFParams) (key AESKey, err error) {
+	if err = params.Validate(); err != nil {
+		return
+	}
+	id, err := cache.identify(password, params)
+	if err != nil {
+		return
+	}
+	cache.lock.Lock()
+	if element, ok := cache.entries[id]; ok {
+		entry := element.Value.(*derivedKeyEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			cache.order.MoveToFront(element)
+			cache.hits++
+			key = entry.key
+			cache.lock.Unlock()
+			return
+		}
+		cache.remove(element)
+	}
+	if call, ok := cache.calls[id]; ok {
+		// Someone else is already deriving this key
+		cache.hits++
+		cache.lock.Unlock()
+		<-call.done
+		return call.key, call.err
+	}
+	call := &derivedKeyCall{done: make(chan struct{})}
+	cache.calls[id] = call
+	cache.misses++
+	cache.lock.Unlock()
+
+	cache.derive(id, call, password, params)
+	return call.key, call.err
+}
+
+// Runs the derivation of call, then caches the key and wakes up the
+// concurrent calls waiting for it (even if the derivation panics)
+func (cache *DerivedKeyCache) derive(id [sha256.Size]byte, call *derivedKeyCall, password string, params KDFParams) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			call.key, call.err = AESKey{}, fmt.Errorf("%w: %v", ErrDerivationPanicked, recovered)
+		}
+		cache.lock.Lock()
+		delete(cache.calls, id)
+		if call.err == nil {
+			cache.add(id, call.key)
+		}
+		cache.lock.Unlock()
+		close(call.done)
+	}()
+	call.key, call.err = DeriveKeyWithParams(password, params)
+}
+
+// Securely derives a key like `DeriveSecureKey`, or returns it from
+// the cache (see `DeriveKey`)
+func (cache *DerivedKeyCache) DeriveSecureKey(password string, salt []byte, time uint32, memory uint32, threads uint8) (key AESKey, err error) {
+	return cache.DeriveKey(password, SecureKeyParams(salt, time, memory, threads))
+}
+
+// Removes the expired keys, overwriting them. Expired keys are
+// otherwise only removed when looked up or evicted.
+func (cache *DerivedKeyCache) RemoveExpired() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	for element := cache.order.Front(); element != nil; {
+		next := element.Next()
+		if expires := element.Value.(*derivedKeyEntry).expires; !expires.IsZero() && !now.Before(expires) {
+			cache.remove(element)
+		}
+		element = next
+	}
+}
+
+// Removes all the keys, overwriting them
+func (cache *DerivedKeyCache) Purge() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for element := cache.order.Front(); element != nil; element = cache.order.Front() {
+		entry := cache.order.Remove(element).(*derivedKeyEntry)
+		delete(cache.entries, entry.id)
+		clear(entry.key[:])
+	}
+}
+
+// Returns the number of keys in the cache (including expired keys
+// that were not removed yet)
+func (cache *DerivedKeyCache) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.order.Len()
+}
+
+// Returns a snapshot of the counters of the cache
+func (cache *DerivedKeyCache) Stats() DerivedKeyCacheStats {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return DerivedKeyCacheStats{
+		Entries:   cache.order.Len(),
+		Hits:      cache.hits,
+		Misses:    cache.misses,
+		Evictions: cache.evictions,
+	}
+}
+
+// Returns the identifier of the entry for a password and params
+func (cache *DerivedKeyCache) identify(password string, params KDFParams) (id [sha256.Size]byte, err error) {
+	encoded, err := params.MarshalBinary()
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, cache.hmacKey[:])
+	// Length-prefixed, so that the password and params can't overlap
+	_ = binary.Write(mac, binary.BigEndian, uint64(len(password)))
+	_, _ = io.WriteString(mac, password)
+	_, _ = mac.Write(encoded)
+	mac.Sum(id[:0])
+	return
+}
+
+// Adds a key, evicting the least recently used keys if needed.
+// Must be called with the lock held.
+func (cache *DerivedKeyCache) add(id [sha256.Size]byte, key AESKey) {
+	if element, ok := cache.entries[id]; ok {
+		cache.remove(element)
+	}
+	entry := &derivedKeyEntry{id: id, key: key}
+	if cache.ttl != 0 {
+		entry.expires = time.Now().Add(cache.ttl)
+	}
+	cache.entries[id] = cache.order.PushFront(entry)
+	for cache.order.Len() > cache.maxEntries {
+		cache.remove(cache.order.Back())
+	}
+}
+
+// Removes an entry, overwriting its key.
+// Must be called with the lock held.
+func (cache *DerivedKeyCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*derivedKeyEntry)
+	delete(cache.entries, entry.id)
+	clear(entry.key[:])
+	cache.evictions++
+}
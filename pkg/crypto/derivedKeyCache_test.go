@@ -0,0 +1,125 @@
+package crypto_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestDerivedKeyCache(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	if _, err := crypto.NewDerivedKeyCache(0, 0); err != crypto.ErrDerivedKeyCacheSize {
+		t.Fatalf("expected ErrDerivedKeyCacheSize, instead got %v", err)
+	}
+	cache, err := crypto.NewDerivedKeyCache(2, 0)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	expected, err := crypto.DeriveKeyWithParams("password", sampleKDFParams)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	// Derived once, then cached
+	for i := 0; i < 3; i++ {
+		if key, err := cache.DeriveKey("password", sampleKDFParams); err != nil || key != expected {
+			t.Fatalf("expected %x, instead got %x (%v)", expected, key, err)
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// The password, salt and parameters all identify an entry
+	otherSalt := sampleKDFParams.Clone()
+	otherSalt.Salt = []byte("othersalt")
+	otherTime := sampleKDFParams.Clone()
+	otherTime.Time++
+	for _, params := range []crypto.KDFParams{otherSalt, otherTime} {
+		key, err := cache.DeriveKey("password", params)
+		if err != nil || key == expected {
+			t.Fatalf("expected a different key (%v)", err)
+		}
+	}
+	if key, err := cache.DeriveKey("Password", sampleKDFParams); err != nil || key == expected {
+		t.Fatalf("expected a different key (%v)", err)
+	}
+	// Only the 2 most recently used keys are kept
+	if stats := cache.Stats(); stats.Misses != 4 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if key, err := cache.DeriveKey("password", sampleKDFParams); err != nil || key != expected {
+		t.Fatalf("expected %x, instead got %x (%v)", expected, key, err)
+	}
+	if stats := cache.Stats(); stats.Misses != 5 {
+		t.Fatalf("expected the evicted key to be derived again, instead got %+v", stats)
+	}
+	// Errors are returned and not cached
+	invalid := sampleKDFParams.Clone()
+	invalid.Time = 0
+	if _, err := cache.DeriveKey("password", invalid); !errors.Is(err, crypto.ErrKDFParams) {
+		t.Fatalf("expected ErrKDFParams, instead got %v", err)
+	}
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Fatalf("expected an empty cache, instead got %d keys", cache.Len())
+	}
+	if key, err := cache.DeriveSecureKey("password", sampleKDFParams.Salt, sampleKDFParams.Time, sampleKDFParams.Memory, sampleKDFParams.Threads); err != nil || key != expected {
+		t.Fatalf("expected %x, instead got %x (%v)", expected, key, err)
+	}
+}
+
+func TestDerivedKeyCacheTTL(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	cache, err := crypto.NewDerivedKeyCache(10, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	if _, err := cache.DeriveKey("password", sampleKDFParams); err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cache.RemoveExpired()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Evictions != 1 {
+		t.Fatalf("expected the key to expire, instead got %+v", stats)
+	}
+	if _, err := cache.DeriveKey("password", sampleKDFParams); err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cache.DeriveKey("password", sampleKDFParams); err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if stats := cache.Stats(); stats.Misses != 3 || stats.Hits != 0 {
+		t.Fatalf("expected expired keys to be derived again, instead got %+v", stats)
+	}
+}
+
+func TestDerivedKeyCacheConcurrent(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	cache, err := crypto.NewDerivedKeyCache(10, 0)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	params := sampleKDFParams.Clone()
+	params.Memory = 32 * 1024 // Slow enough for most calls to overlap
+	keys := make([]crypto.AESKey, 8)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], _ = cache.DeriveKey("password", params)
+		}(i)
+	}
+	wg.Wait()
+	for _, key := range keys {
+		if key != keys[0] || key == (crypto.AESKey{}) {
+			t.Fatalf("expected all callers to get %x, instead got %x", keys[0], key)
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 7 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -1,6 +1,15 @@
 package crypto
 
-import "github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+import (
+	"crypto/rand"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+const (
+	// The size of the nonce each ciphertext starts with
+	NonceSize = helpers.NonceSize
+)
 
 // Encrypt a message using the provided key.
 func Encrypt(key AESKey, plaintext []byte) (ciphertext []byte, err error) {
@@ -11,3 +20,17 @@ func Encrypt(key AESKey, plaintext []byte) (ciphertext []byte, err error) {
 func Decrypt(key AESKey, ciphertext []byte) (plaintext []byte, err error) {
 	return helpers.Decrypt(key, ciphertext)
 }
+
+// Encrypts a message using the provided key, also authenticating (but
+// not encrypting) additionalData, such as a header sent in plaintext.
+//
+// The same additionalData must be given to `DecryptWithData`.
+func EncryptWithData(key AESKey, plaintext []byte, additionalData []byte) (ciphertext []byte, err error) {
+	return helpers.SealWithData(helpers.NewAEAD(key), rand.Reader, nil, plaintext, additionalData)
+}
+
+// Decrypts a message from `EncryptWithData` using the provided key and
+// the same additionalData
+func DecryptWithData(key AESKey, ciphertext []byte, additionalData []byte) (plaintext []byte, err error) {
+	return helpers.OpenWithData(helpers.NewAEAD(key), nil, ciphertext, additionalData)
+}
@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var (
+	// Error returned when creating a testing-only `Entropy` outside
+	// of tests without `AllowTestingEntropy`
+	ErrTestingEntropy = errors.New("entropy: testing-only entropy sources can only be used in tests (see AllowTestingEntropy)")
+	// Error returned when creating a testing-only `Entropy` without
+	// a reader
+	ErrNilEntropyReader = errors.New("entropy: the reader is nil")
+)
+
+var (
+	// The system's cryptographically secure random source
+	systemEntropy = &Entropy{}
+	// Whether testing-only sources were explicitly allowed
+	testingEntropyAllowed atomic.Bool
+)
+
+// A source of randomness for nonces, keys, salts and handshakes.
+//
+// Everything in this library uses the system's cryptographically
+// secure random source (`SystemEntropy`) by default. Other sources
+// are for deterministic tests only: they make ciphertexts and keys
+// predictable, which is never safe in production.
+//
+// An Entropy is safe for concurrent use. A nil *Entropy and the zero
+// value are the same as `SystemEntropy`.
+type Entropy struct {
+	// Guards reader (nil for the system's source, which needs no lock)
+	lock *sync.Mutex
+	// The source of randomness (nil for the system's source)
+	reader io.Reader
+}
+
+// Returns the system's cryptographically secure random source
+func SystemEntropy() *Entropy {
+	return systemEntropy
+}
+
+// Returns a testing-only `Entropy` reading from reader, which makes
+// the results of the functions using it reproducible.
+//
+// Returns `ErrTestingEntropy` unless called from a test binary
+// (`go test`) or after `AllowTestingEntropy`, and
+// `ErrNilEntropyReader` if reader is nil.
+func NewTestingEntropy(reader io.Reader) (entropy *Entropy, err error) {
+	if !testing.Testing() && !testingEntropyAllowed.Load() {
+		return nil, ErrTestingEntropy
+	}
+	if reader == nil {
+		return nil, ErrNilEntropyReader
+	}
+	return &Entropy{lock: &sync.Mutex{}, reader: reader}, nil
+}
+
+// Returns a testing-only `Entropy` producing an endless, reproducible
+// stream of bytes from a seed (see `NewTestingEntropy`)
+func NewSeededTestingEntropy(seed []byte) (*Entropy, error) {
+	return NewTestingEntropy(&seededReader{seed: append([]byte(nil), seed...)})
+}
+
+// Allows testing-only `Entropy` sources outside of test binaries,
+// for the whole process, for example in a fuzzing or simulation
+// harness.
+//
+// NOTE: never call this in production code.
+func AllowTestingEntropy() {
+	testingEntropyAllowed.Store(true)
+}
+
+// Returns true if entropy is a testing-only source
+func (entropy *Entropy) IsTesting() bool {
+	return entropy != nil && entropy.reader != nil
+}
+
+// Fills p with random bytes (implementing io.Reader); it only
+// returns an error if the source fails
+func (entropy *Entropy) Read(p []byte) (n int, err error) {
+	if !entropy.IsTesting() {
+		return io.ReadFull(rand.Reader, p)
+	}
+	entropy.lock.Lock()
+	defer entropy.lock.Unlock()
+	return io.ReadFull(entropy.reader, p)
+}
+
+// Generates a new random key (see `NewKey`)
+func (entropy *Entropy) NewKey() (key AESKey, err error) {
+	_, err = entropy.Read(key[:])
+	return
+}
+
+// Generates a new random salt (see `NewSalt`)
+func (entropy *Entropy) NewSalt() (salt []byte, err error) {
+	salt = make([]byte, KDFSaltSize)
+	if _, err = entropy.Read(salt); err != nil {
+		salt = nil
+	}
+	return
+}
+
+// An endless stream of bytes: SHA256(seed || counter) for counter
+// 0, 1, 2, ...
+type seededReader struct {
+	// The seed of the stream
+	seed []byte
+	// The index of the next block
+	counter uint64
+	// The unread bytes of the current block
+	block []byte
+}
+
+// Fills p with the next bytes of the stream
+func (reader *seededReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(reader.block) == 0 {
+			digest := sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte(nil), reader.seed...), reader.counter))
+			reader.block = digest[:]
+			reader.counter++
+		}
+		copied := copy(p[n:], reader.block)
+		reader.block = reader.block[copied:]
+		n += copied
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestTestingEntropy(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	if crypto.SystemEntropy().IsTesting() {
+		t.Fatalf("expected the system entropy not to be testing-only")
+	}
+	// The zero value is the system entropy
+	if entropy := (&crypto.Entropy{}); entropy.IsTesting() {
+		t.Fatalf("expected the zero value not to be testing-only")
+	} else if key, err := entropy.NewKey(); err != nil || key == (crypto.AESKey{}) {
+		t.Fatalf("unexpected key %x (%v)", key, err)
+	}
+	// Allowed in tests without AllowTestingEntropy, but not without
+	// a reader
+	if entropy, err := crypto.NewTestingEntropy(nil); !errors.Is(err, crypto.ErrNilEntropyReader) {
+		t.Fatalf("expected ErrNilEntropyReader, instead got %v (%v)", entropy, err)
+	}
+	// A fixed stream
+	entropy, err := crypto.NewTestingEntropy(bytes.NewReader(bytes.Repeat([]byte{7}, 64)))
+	if err != nil {
+		t.Fatalf("failed to create testing entropy: %v", err)
+	}
+	if !entropy.IsTesting() {
+		t.Fatalf("expected the entropy to be testing-only")
+	}
+	key, err := entropy.NewKey()
+	if err != nil || key != crypto.AESKey(bytes.Repeat([]byte{7}, 32)) {
+		t.Fatalf("unexpected key %x (%v)", key, err)
+	}
+	if _, err := entropy.NewKey(); err != nil {
+		t.Fatalf("failed to read the rest of the stream: %v", err)
+	}
+	if _, err := entropy.NewSalt(); err == nil {
+		t.Fatalf("expected an error once the stream is exhausted")
+	}
+	// Seeded streams are reproducible
+	results := [2][]byte{}
+	for i := range results {
+		entropy, err := crypto.NewSeededTestingEntropy([]byte("seed"))
+		if err != nil {
+			t.Fatalf("failed to create testing entropy: %v", err)
+		}
+		salt, err := entropy.NewSalt()
+		if err != nil {
+			t.Fatalf("failed to generate salt: %v", err)
+		}
+		ciphertext, err := crypto.NewSealerWithEntropy(key, entropy).Encrypt([]byte("hello gopher"))
+		if err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+		results[i] = append(salt, ciphertext...)
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Fatalf("expected the same results, instead got %x and %x", results[0], results[1])
+	}
+}
+
+func TestSealer(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := crypto.DeriveKey("hello gopher")
+	sealer := crypto.NewSealer(key)
+	ciphertext, err := sealer.Encrypt([]byte("some data"))
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	// Compatible with Encrypt and Decrypt
+	if plaintext, err := crypto.Decrypt(key, ciphertext); err != nil || string(plaintext) != "some data" {
+		t.Fatalf("unexpected plaintext %q (%v)", plaintext, err)
+	}
+	if ciphertext, err = crypto.Encrypt(key, []byte("other data")); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if plaintext, err := sealer.Decrypt(ciphertext); err != nil || string(plaintext) != "other data" {
+		t.Fatalf("unexpected plaintext %q (%v)", plaintext, err)
+	}
+	// Tampering is detected
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := sealer.Decrypt(ciphertext); err == nil {
+		t.Fatalf("expected an error decrypting a tampered ciphertext")
+	}
+	if _, err := sealer.Decrypt(ciphertext[:crypto.NonceSize-1]); err == nil {
+		t.Fatalf("expected an error decrypting a short ciphertext")
+	}
+}
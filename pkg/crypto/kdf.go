@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+const (
+	// The maximum number of threads `RecommendedKDFParams` will use;
+	// past this point more lanes mostly starve concurrent derivations
+	MaxRecommendedParallelism uint8 = 16
+	// The minimum memory (in KiB) `CalibrateKDF` will go down to
+	MinCalibrationMemory uint32 = 19 * 1024 // 19 MiB
+)
+
+var (
+	// Error returned when `CalibrateKDF` is given a non-positive target
+	ErrCalibrationDuration = errors.New("kdf calibration: the target duration must be positive")
+	// Error returned when `CalibrateKDF` is given less memory than
+	// `MinCalibrationMemory`
+	ErrCalibrationMemory = errors.New("kdf calibration: the memory limit is below the minimum")
+)
+
+// Returns the default Argon2id parameters with a number of threads
+// matching the CPUs available on this machine (capped at
+// `MaxRecommendedParallelism`).
+//
+// The returned parameters have no salt.
+func RecommendedKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      DefaultTime,
+		Memory:    DefaultMemory,
+		Threads:   recommendedThreads(),
+		KeyLength: helpers.KeySize,
+	}
+}
+
+// Benchmarks this machine and returns parameters for which a key
+// derivation takes about targetDuration, using at most maxMemory KiB.
+//
+// The parameters never exceed `MaxKDFMemory` and `MaxKDFTime`, so a
+// derivation can take less than targetDuration on fast machines.
+//
+// The memory is kept as high as possible (starting from maxMemory),
+// and only reduced if a single pass already takes longer than
+// targetDuration; the number of passes is then raised to fill the
+// remaining time.
+//
+// The returned parameters have no salt.
+func CalibrateKDF(targetDuration time.Duration, maxMemory uint32) (params KDFParams, err error) {
+	if targetDuration <= 0 {
+		err = ErrCalibrationDuration
+		return
+	}
+	if maxMemory < MinCalibrationMemory {
+		err = ErrCalibrationMemory
+		return
+	}
+	params = KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      1,
+		Memory:    min(maxMemory, MaxKDFMemory),
+		Threads:   recommendedThreads(),
+		KeyLength: helpers.KeySize,
+	}
+	// Find the memory for which a single pass fits in the target
+	elapsed := measureKDF(params)
+	for elapsed > targetDuration && params.Memory/2 >= MinCalibrationMemory {
+		params.Memory /= 2
+		elapsed = measureKDF(params)
+	}
+	// Spend the rest of the time on more passes (the cost of
+	// Argon2id is roughly linear in the number of passes)
+	if passes := targetDuration / max(elapsed, 1); passes > 1 {
+		params.Time = uint32(min(passes, time.Duration(MaxKDFTime)))
+	}
+	return
+}
+
+// Returns the number of threads to use on this machine
+func recommendedThreads() uint8 {
+	return uint8(max(1, min(runtime.NumCPU(), int(MaxRecommendedParallelism))))
+}
+
+// Returns the time it takes to derive a key with the given parameters
+func measureKDF(params KDFParams) time.Duration {
+	start := time.Now()
+	_ = helpers.DeriveKeySecure("calibration password", make([]byte, KDFSaltSize), params.Time, params.Memory, params.Threads)
+	return time.Since(start)
+}
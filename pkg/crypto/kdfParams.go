@@ -0,0 +1,599 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+const (
+	// The size of the salts generated by `NewKDFParams`
+	KDFSaltSize = 16
+	// The minimum salt length accepted by `KDFParams.Validate`
+	MinKDFSaltSize = 8
+	// The minimum key length accepted by `KDFParams.Validate`
+	MinKDFKeyLength uint32 = 16
+	// The maximum key length accepted by `KDFParams.Validate`
+	MaxKDFKeyLength uint32 = 1024
+	// The maximum number of passes (Argon2id) accepted by
+	// `KDFParams.Validate`
+	MaxKDFTime uint32 = 64
+	// The maximum memory (in KiB) accepted by `KDFParams.Validate`
+	MaxKDFMemory uint32 = 1024 * 1024 // 1 GiB
+	// The maximum number of threads (Argon2id) or parallelism (scrypt)
+	// accepted by `KDFParams.Validate`
+	MaxKDFThreads uint8 = 64
+	// The maximum number of PBKDF2 iterations, for each 32 bytes of
+	// the key, accepted by `KDFParams.Validate`
+	MaxPBKDF2Iterations uint32 = 10_000_000
+	// Default cost (as log2 of N) for scrypt
+	DefaultScryptCost uint32 = 17
+	// Default block size (r) for scrypt
+	DefaultScryptBlockSize uint32 = 8
+	// Default parallelism (p) for scrypt
+	DefaultScryptParallelism uint8 = 1
+	// Default number of iterations for PBKDF2-SHA256
+	DefaultPBKDF2Iterations uint32 = 600_000
+	// The version of the binary encoding of `KDFParams`
+	kdfParamsBinaryVersion uint8 = 1
+	// The Argon2 version implemented by golang.org/x/crypto/argon2
+	argon2Version = 0x13
+)
+
+// A key derivation algorithm
+type KDFAlgorithm uint8
+
+const (
+	// Argon2id, as used by `DeriveSecureKey`
+	KDFArgon2id KDFAlgorithm = 1
+	// scrypt (RFC 7914)
+	KDFScrypt KDFAlgorithm = 2
+	// PBKDF2 with HMAC-SHA256 (RFC 8018)
+	KDFPBKDF2SHA256 KDFAlgorithm = 3
+	// HKDF with SHA256 (RFC 5869); it has no work factor, so it must
+	// only be used with high-entropy secrets (see `CompositeKey`),
+	// and the password functions reject it
+	KDFHKDFSHA256 KDFAlgorithm = 4
+)
+
+var (
+	// Error returned when the algorithm of some `KDFParams` is not supported
+	ErrKDFAlgorithm = errors.New("kdf: unsupported algorithm")
+	// Error returned when some `KDFParams` are invalid
+	ErrKDFParams = errors.New("kdf: invalid parameters")
+	// Error returned when some `KDFParams` are weaker than a `KDFPolicy`
+	ErrKDFTooWeak = errors.New("kdf: parameters are weaker than the policy")
+	// Error returned when `KDFParams` can't be decoded
+	ErrKDFEncoding = errors.New("kdf: invalid encoding")
+	// Error returned when deriving an `AESKey` with a key length
+	// other than the AES key size
+	ErrKDFKeyLength = errors.New("kdf: the key length doesn't match the AES key size")
+	// Error returned when hashing or deriving a key from a password
+	// with an algorithm without a work factor (HKDF)
+	ErrKDFNotForPasswords = errors.New("kdf: the algorithm has no work factor and can't be used with passwords")
+)
+
+var (
+	// The default minimum-strength policy, following the OWASP
+	// recommendations for password storage
+	DefaultKDFPolicy = KDFPolicy{
+		MinSaltLength: KDFSaltSize,
+		MinKeyLength:  helpers.KeySize,
+		Argon2id: KDFParams{
+			Time:    1,
+			Memory:  19 * 1024, // 19 MiB
+			Threads: 1,
+		},
+		Scrypt: KDFParams{
+			Time:      17, // N = 2^17
+			BlockSize: 8,
+			Threads:   1,
+		},
+		PBKDF2: KDFParams{
+			Time: 600_000,
+		},
+	}
+	// The PHC string parameter names of each algorithm, in order
+	phcParamNames = map[KDFAlgorithm][]string{
+		KDFArgon2id:     {"m", "t", "p"},
+		KDFScrypt:       {"ln", "r", "p"},
+		KDFPBKDF2SHA256: {"i"},
+		KDFHKDFSHA256:   {},
+	}
+)
+
+// A full description of a key derivation: re-deriving a key with the
+// same password and `KDFParams` always yields the same key.
+//
+// The meaning of the cost fields depends on the algorithm, and the
+// fields an algorithm doesn't use must be 0:
+//   - Argon2id: Time, Memory and Threads
+//   - scrypt: Time (the cost, as log2 of N), BlockSize (r) and Threads (p)
+//   - PBKDF2-SHA256: Time (the number of iterations)
+//   - HKDF-SHA256: none
+type KDFParams struct {
+	// The key derivation algorithm
+	Algorithm KDFAlgorithm
+	// The salt
+	Salt []byte
+	// The number of passes over the memory (Argon2id), the cost as
+	// log2 of N (scrypt) or the number of iterations (PBKDF2)
+	Time uint32
+	// The amount of memory to use in KiB (Argon2id)
+	Memory uint32
+	// The number of threads (Argon2id) or the parallelism (scrypt)
+	Threads uint8
+	// The block size (scrypt)
+	BlockSize uint32
+	// The length of the derived key (in bytes)
+	KeyLength uint32
+}
+
+// A minimum-strength policy for `KDFParams`
+type KDFPolicy struct {
+	// The minimum salt length (in bytes)
+	MinSaltLength int
+	// The minimum key length (in bytes)
+	MinKeyLength uint32
+	// The minimum time, memory and threads for Argon2id
+	Argon2id KDFParams
+	// The minimum cost, block size and parallelism for scrypt
+	Scrypt KDFParams
+	// The minimum number of iterations for PBKDF2
+	PBKDF2 KDFParams
+	// Whether HKDF, which has no work factor, is allowed
+	AllowHKDF bool
+}
+
+// Returns the name of the algorithm, as used in PHC strings
+func (algorithm KDFAlgorithm) String() string {
+	switch algorithm {
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	case KDFPBKDF2SHA256:
+		return "pbkdf2-sha256"
+	case KDFHKDFSHA256:
+		return "hkdf-sha256"
+	default:
+		return fmt.Sprintf("KDFAlgorithm(%d)", uint8(algorithm))
+	}
+}
+
+// Returns `RecommendedKDFParams` with a new random salt
+func NewKDFParams() (params KDFParams, err error) {
+	params = RecommendedKDFParams()
+	params.Salt, err = NewSalt()
+	return
+}
+
+// Returns the default parameters for an algorithm (without a salt)
+func RecommendedKDFParamsFor(algorithm KDFAlgorithm) (params KDFParams, err error) {
+	switch algorithm {
+	case KDFArgon2id:
+		params = RecommendedKDFParams()
+	case KDFScrypt:
+		params = KDFParams{Time: DefaultScryptCost, BlockSize: DefaultScryptBlockSize, Threads: DefaultScryptParallelism}
+	case KDFPBKDF2SHA256:
+		params = KDFParams{Time: DefaultPBKDF2Iterations}
+	case KDFHKDFSHA256:
+	default:
+		err = fmt.Errorf("%w: %v", ErrKDFAlgorithm, algorithm)
+		return
+	}
+	params.Algorithm = algorithm
+	params.KeyLength = helpers.KeySize
+	return
+}
+
+// Returns the parameters `DeriveSecureKey` uses for the given
+// arguments, after replacing `nil` and `0` with the defaults
+func SecureKeyParams(salt []byte, time uint32, memory uint32, threads uint8) KDFParams {
+	// Set default salt if provided value is nil
+	if salt == nil {
+		salt = DefaultSalt
+	}
+	// Set default time if not provided
+	if time == 0 {
+		time = DefaultTime
+	}
+	// Set default memory if not provided
+	if memory == 0 {
+		memory = DefaultMemory
+	}
+	// Set default threads if not provided
+	if threads == 0 {
+		threads = DefaultParallelism
+	}
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Salt:      salt,
+		Time:      time,
+		Memory:    memory,
+		Threads:   threads,
+		KeyLength: helpers.KeySize,
+	}
+}
+
+// Derives a key from a password as described by params.
+//
+// The params must be valid, use an algorithm with a work factor (not
+// HKDF) and have a `KeyLength` matching the size of an `AESKey`.
+func DeriveKeyWithParams(password string, params KDFParams) (key AESKey, err error) {
+	if err = params.validateForPasswords(); err != nil {
+		return
+	}
+	if params.KeyLength != helpers.KeySize {
+		err = ErrKDFKeyLength
+		return
+	}
+	derived, err := deriveBytes([]byte(password), params)
+	if err != nil {
+		return
+	}
+	copy(key[:], derived)
+	clear(derived)
+	return
+}
+
+// Returns an error wrapping `ErrKDFAlgorithm` or `ErrKDFParams` if
+// the parameters can't be used for a key derivation.
+//
+// Parameters above the maximums (such as `MaxKDFMemory`) are invalid,
+// so that parameters parsed from an untrusted source can't make a
+// derivation exhaust the memory or the CPU.
+func (params KDFParams) Validate() error {
+	switch params.Algorithm {
+	case KDFArgon2id:
+		if params.Time < 1 || params.Time > MaxKDFTime {
+			return fmt.Errorf("%w: time must be between 1 and %d", ErrKDFParams, MaxKDFTime)
+		}
+		if params.Threads < 1 || params.Threads > MaxKDFThreads {
+			return fmt.Errorf("%w: threads must be between 1 and %d", ErrKDFParams, MaxKDFThreads)
+		}
+		if params.Memory < 8*uint32(params.Threads) || params.Memory > MaxKDFMemory {
+			return fmt.Errorf("%w: memory must be at least 8 KiB per thread and at most %d KiB", ErrKDFParams, MaxKDFMemory)
+		}
+		if params.BlockSize != 0 {
+			return fmt.Errorf("%w: %v doesn't use a block size", ErrKDFParams, params.Algorithm)
+		}
+	case KDFScrypt:
+		if params.Time < 1 || params.Time > 62 {
+			return fmt.Errorf("%w: the cost (log2 of N) must be between 1 and 62", ErrKDFParams)
+		}
+		if params.BlockSize < 1 || params.Threads < 1 {
+			return fmt.Errorf("%w: the block size and parallelism must be at least 1", ErrKDFParams)
+		}
+		if uint64(params.BlockSize)*uint64(params.Threads) >= 1<<30 || uint64(1)<<params.Time > math.MaxInt/128/uint64(params.BlockSize) {
+			return fmt.Errorf("%w: the scrypt parameters are too large", ErrKDFParams)
+		}
+		if params.MemoryCost() > uint64(MaxKDFMemory) || params.Threads > MaxKDFThreads {
+			return fmt.Errorf("%w: scrypt must use at most %d KiB and a parallelism of %d", ErrKDFParams, MaxKDFMemory, MaxKDFThreads)
+		}
+		if params.Memory != 0 {
+			return fmt.Errorf("%w: %v doesn't use memory", ErrKDFParams, params.Algorithm)
+		}
+	case KDFPBKDF2SHA256:
+		if params.Time < 1 || uint64(params.Time)*uint64((params.KeyLength+31)/32) > uint64(MaxPBKDF2Iterations) {
+			return fmt.Errorf("%w: the number of iterations must be between 1 and %d for each 32 bytes of the key", ErrKDFParams, MaxPBKDF2Iterations)
+		}
+		if params.Memory != 0 || params.Threads != 0 || params.BlockSize != 0 {
+			return fmt.Errorf("%w: %v only uses the number of iterations", ErrKDFParams, params.Algorithm)
+		}
+	case KDFHKDFSHA256:
+		if params.Time != 0 || params.Memory != 0 || params.Threads != 0 || params.BlockSize != 0 {
+			return fmt.Errorf("%w: %v doesn't use cost parameters", ErrKDFParams, params.Algorithm)
+		}
+	default:
+		return fmt.Errorf("%w: %v", ErrKDFAlgorithm, params.Algorithm)
+	}
+	if len(params.Salt) < MinKDFSaltSize || len(params.Salt) > math.MaxUint16 {
+		return fmt.Errorf("%w: the salt must be between %d and %d bytes", ErrKDFParams, MinKDFSaltSize, math.MaxUint16)
+	}
+	if params.KeyLength < MinKDFKeyLength || params.KeyLength > MaxKDFKeyLength {
+		return fmt.Errorf("%w: the key length must be between %d and %d bytes", ErrKDFParams, MinKDFKeyLength, MaxKDFKeyLength)
+	}
+	return nil
+}
+
+// Returns the error from `Validate`, or `ErrKDFNotForPasswords` for
+// an algorithm without a work factor
+func (params KDFParams) validateForPasswords() error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	if params.Algorithm == KDFHKDFSHA256 {
+		return fmt.Errorf("%w: %v", ErrKDFNotForPasswords, params.Algorithm)
+	}
+	return nil
+}
+
+// Returns an error wrapping `ErrKDFTooWeak` if the parameters are
+// weaker than the policy, or the error from `Validate` if invalid
+func (params KDFParams) CheckPolicy(policy KDFPolicy) (err error) {
+	if err = params.Validate(); err != nil {
+		return
+	}
+	if len(params.Salt) < policy.MinSaltLength {
+		return fmt.Errorf("%w: the salt must be at least %d bytes", ErrKDFTooWeak, policy.MinSaltLength)
+	}
+	if params.KeyLength < policy.MinKeyLength {
+		return fmt.Errorf("%w: the key length must be at least %d bytes", ErrKDFTooWeak, policy.MinKeyLength)
+	}
+	switch params.Algorithm {
+	case KDFArgon2id:
+		minimum := policy.Argon2id
+		if params.Time < minimum.Time || params.Memory < minimum.Memory || params.Threads < minimum.Threads {
+			return fmt.Errorf("%w: %v requires at least t=%d, m=%d, p=%d", ErrKDFTooWeak, params.Algorithm, minimum.Time, minimum.Memory, minimum.Threads)
+		}
+	case KDFScrypt:
+		minimum := policy.Scrypt
+		if params.Time < minimum.Time || params.BlockSize < minimum.BlockSize || params.Threads < minimum.Threads {
+			return fmt.Errorf("%w: %v requires at least ln=%d, r=%d, p=%d", ErrKDFTooWeak, params.Algorithm, minimum.Time, minimum.BlockSize, minimum.Threads)
+		}
+	case KDFPBKDF2SHA256:
+		if params.Time < policy.PBKDF2.Time {
+			return fmt.Errorf("%w: %v requires at least %d iterations", ErrKDFTooWeak, params.Algorithm, policy.PBKDF2.Time)
+		}
+	case KDFHKDFSHA256:
+		if !policy.AllowHKDF {
+			return fmt.Errorf("%w: %v is not allowed", ErrKDFTooWeak, params.Algorithm)
+		}
+	}
+	return
+}
+
+// Returns the approximate memory (in KiB) a derivation with these
+// (valid) parameters uses, rounded up
+func (params KDFParams) MemoryCost() uint64 {
+	switch params.Algorithm {
+	case KDFArgon2id:
+		return uint64(params.Memory)
+	case KDFScrypt:
+		// V (128*r*N), B (128*r*p) and XY (256*r) in bytes
+		blockSize := uint64(params.BlockSize)
+		bytes := 128*blockSize*(uint64(1)<<params.Time) + 128*blockSize*uint64(params.Threads) + 256*blockSize
+		return (bytes + 1023) / 1024
+	default:
+		return 0
+	}
+}
+
+// Returns a copy of the parameters that doesn't share the salt
+func (params KDFParams) Clone() KDFParams {
+	params.Salt = append([]byte(nil), params.Salt...)
+	return params
+}
+
+// Returns the parameters encoded as a PHC string without a hash,
+// for example "$argon2id$v=19$m=126976,t=2,p=8$c29tZXNhbHQ",
+// "$scrypt$ln=17,r=8,p=1$c29tZXNhbHQ" or
+// "$pbkdf2-sha256$i=600000$c29tZXNhbHQ".
+//
+// The key length is not part of the PHC string.
+func (params KDFParams) String() string {
+	return string(params.appendPHC(nil))
+}
+
+// Encodes the parameters as a PHC string (see `String`)
+func (params KDFParams) MarshalText() (text []byte, err error) {
+	if err = params.Validate(); err != nil {
+		return
+	}
+	return params.appendPHC(nil), nil
+}
+
+// Decodes parameters from a PHC string (see `ParseKDFParams`)
+func (params *KDFParams) UnmarshalText(text []byte) (err error) {
+	parsed, err := ParseKDFParams(string(text))
+	if err != nil {
+		return
+	}
+	*params = parsed
+	return
+}
+
+// Encodes the parameters in a stable binary format
+func (params KDFParams) MarshalBinary() (data []byte, err error) {
+	if err = params.Validate(); err != nil {
+		return
+	}
+	data = append(data, kdfParamsBinaryVersion, uint8(params.Algorithm))
+	data = binary.BigEndian.AppendUint32(data, params.Time)
+	data = binary.BigEndian.AppendUint32(data, params.Memory)
+	data = append(data, params.Threads)
+	data = binary.BigEndian.AppendUint32(data, params.BlockSize)
+	data = binary.BigEndian.AppendUint32(data, params.KeyLength)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(params.Salt)))
+	data = append(data, params.Salt...)
+	return
+}
+
+// Decodes parameters encoded with `MarshalBinary`
+func (params *KDFParams) UnmarshalBinary(data []byte) (err error) {
+	// Version (1) + Algorithm (1) + Time (4) + Memory (4) + Threads (1)
+	// + Block size (4) + Key length (4) + Salt length (2)
+	const fixedSize = 1 + 1 + 4 + 4 + 1 + 4 + 4 + 2
+	if len(data) < fixedSize || data[0] != kdfParamsBinaryVersion {
+		return ErrKDFEncoding
+	}
+	decoded := KDFParams{
+		Algorithm: KDFAlgorithm(data[1]),
+		Time:      binary.BigEndian.Uint32(data[2:6]),
+		Memory:    binary.BigEndian.Uint32(data[6:10]),
+		Threads:   data[10],
+		BlockSize: binary.BigEndian.Uint32(data[11:15]),
+		KeyLength: binary.BigEndian.Uint32(data[15:19]),
+	}
+	saltSize := int(binary.BigEndian.Uint16(data[19:21]))
+	data = data[fixedSize:]
+	if len(data) != saltSize {
+		return ErrKDFEncoding
+	}
+	decoded.Salt = append([]byte(nil), data...)
+	if err = decoded.Validate(); err != nil {
+		return
+	}
+	*params = decoded
+	return
+}
+
+// Parses parameters from a PHC string (see `String`).
+//
+// If the string contains a hash, the key length is set to its
+// length, otherwise it is set to the size of an `AESKey`.
+func ParseKDFParams(encoded string) (params KDFParams, err error) {
+	params, hash, err := parsePHC(encoded)
+	clear(hash)
+	return
+}
+
+// Appends the PHC string for the parameters to dst
+func (params KDFParams) appendPHC(dst []byte) []byte {
+	dst = append(dst, '$')
+	dst = append(dst, params.Algorithm.String()...)
+	if params.Algorithm == KDFArgon2id {
+		dst = append(dst, "$v="...)
+		dst = strconv.AppendInt(dst, argon2Version, 10)
+	}
+	if names := phcParamNames[params.Algorithm]; len(names) > 0 {
+		values := params.phcValues()
+		for i, name := range names {
+			if i == 0 {
+				dst = append(dst, '$')
+			} else {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, name...)
+			dst = append(dst, '=')
+			dst = strconv.AppendUint(dst, values[i], 10)
+		}
+	}
+	dst = append(dst, '$')
+	dst = append(dst, base64.RawStdEncoding.EncodeToString(params.Salt)...)
+	return dst
+}
+
+// Returns the values of the PHC parameters (see `phcParamNames`)
+func (params KDFParams) phcValues() []uint64 {
+	switch params.Algorithm {
+	case KDFArgon2id:
+		return []uint64{uint64(params.Memory), uint64(params.Time), uint64(params.Threads)}
+	case KDFScrypt:
+		return []uint64{uint64(params.Time), uint64(params.BlockSize), uint64(params.Threads)}
+	case KDFPBKDF2SHA256:
+		return []uint64{uint64(params.Time)}
+	default:
+		return nil
+	}
+}
+
+// Parses a PHC string, returning the parameters and the (optional) hash
+func parsePHC(encoded string) (params KDFParams, hash []byte, err error) {
+	// "", algorithm[, version][, parameters], salt[, hash]
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 3 || fields[0] != "" {
+		err = ErrKDFEncoding
+		return
+	}
+	for _, algorithm := range []KDFAlgorithm{KDFArgon2id, KDFScrypt, KDFPBKDF2SHA256, KDFHKDFSHA256} {
+		if fields[1] == algorithm.String() {
+			params.Algorithm = algorithm
+		}
+	}
+	if params.Algorithm == 0 {
+		err = fmt.Errorf("%w: %q", ErrKDFAlgorithm, fields[1])
+		return
+	}
+	fields = fields[2:]
+	// Version (Argon2id only)
+	if params.Algorithm == KDFArgon2id {
+		if fields[0] != "v="+strconv.Itoa(argon2Version) {
+			err = fmt.Errorf("%w: unsupported argon2 version %q", ErrKDFEncoding, fields[0])
+			return
+		}
+		fields = fields[1:]
+	}
+	// Parameters (salts and hashes never contain '=' as they are not padded)
+	values := map[string]uint64{}
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, field := range strings.Split(fields[0], ",") {
+			name, value, found := strings.Cut(field, "=")
+			if _, seen := values[name]; !found || seen {
+				err = ErrKDFEncoding
+				return
+			}
+			if values[name], err = strconv.ParseUint(value, 10, 32); err != nil {
+				err = fmt.Errorf("%w: %v", ErrKDFEncoding, err)
+				return
+			}
+		}
+		fields = fields[1:]
+	}
+	names := phcParamNames[params.Algorithm]
+	if len(values) != len(names) {
+		err = fmt.Errorf("%w: %v expects the parameters %q", ErrKDFEncoding, params.Algorithm, names)
+		return
+	}
+	for _, name := range names {
+		if _, found := values[name]; !found {
+			err = fmt.Errorf("%w: %v expects the parameters %q", ErrKDFEncoding, params.Algorithm, names)
+			return
+		}
+	}
+	if values["p"] > math.MaxUint8 {
+		err = fmt.Errorf("%w: p must be at most %d", ErrKDFEncoding, math.MaxUint8)
+		return
+	}
+	switch params.Algorithm {
+	case KDFArgon2id:
+		params.Memory, params.Time, params.Threads = uint32(values["m"]), uint32(values["t"]), uint8(values["p"])
+	case KDFScrypt:
+		params.Time, params.BlockSize, params.Threads = uint32(values["ln"]), uint32(values["r"]), uint8(values["p"])
+	case KDFPBKDF2SHA256:
+		params.Time = uint32(values["i"])
+	}
+	// Salt and (optional) hash
+	if len(fields) < 1 || len(fields) > 2 {
+		err = ErrKDFEncoding
+		return
+	}
+	if params.Salt, err = base64.RawStdEncoding.DecodeString(fields[0]); err != nil {
+		err = fmt.Errorf("%w: salt: %v", ErrKDFEncoding, err)
+		return
+	}
+	params.KeyLength = helpers.KeySize
+	if len(fields) == 2 {
+		if hash, err = base64.RawStdEncoding.DecodeString(fields[1]); err != nil {
+			err = fmt.Errorf("%w: hash: %v", ErrKDFEncoding, err)
+			return
+		}
+		params.KeyLength = uint32(len(hash))
+	}
+	err = params.Validate()
+	return
+}
+
+// Derives params.KeyLength bytes from password (params must be valid)
+func deriveBytes(password []byte, params KDFParams) (derived []byte, err error) {
+	switch params.Algorithm {
+	case KDFArgon2id:
+		derived = helpers.DeriveBytesSecure(password, params.Salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	case KDFScrypt:
+		derived, err = helpers.DeriveBytesScrypt(password, params.Salt, uint8(params.Time), int(params.BlockSize), int(params.Threads), int(params.KeyLength))
+	case KDFPBKDF2SHA256:
+		derived = helpers.DeriveBytesPBKDF2(password, params.Salt, int(params.Time), int(params.KeyLength))
+	case KDFHKDFSHA256:
+		derived, err = helpers.DeriveBytesHKDF(password, params.Salt, nil, int(params.KeyLength))
+	default:
+		err = fmt.Errorf("%w: %v", ErrKDFAlgorithm, params.Algorithm)
+	}
+	return
+}
@@ -0,0 +1,335 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+var (
+	// A set of small, valid parameters for testing
+	sampleKDFParams = crypto.KDFParams{
+		Algorithm: crypto.KDFArgon2id,
+		Salt:      []byte("somesalt"),
+		Time:      3,
+		Memory:    4096,
+		Threads:   1,
+		KeyLength: 32,
+	}
+	// The PHC string for sampleKDFParams and the password "somepassword"
+	//
+	// `echo -n "somepassword" | argon2 "somesalt" -id -t 3 -k 4096 -p 1 -l 32 -e`
+	samplePHC = "$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$6ALX8d6C2230oF0MctCeedWZ7Td2/lOL+d32zF6JCSU"
+	// Test cases for the algorithms (computed with Python's hashlib
+	// and hmac modules)
+	kdfAlgorithmTestCases = []struct {
+		params   crypto.KDFParams
+		password string
+		phc      string
+		keyHex   string
+	}{
+		{
+			params:   crypto.KDFParams{Algorithm: crypto.KDFScrypt, Salt: []byte("somesalt"), Time: 10, BlockSize: 8, Threads: 1, KeyLength: 32},
+			password: "password",
+			phc:      "$scrypt$ln=10,r=8,p=1$c29tZXNhbHQ",
+			keyHex:   "c1d5e85848a0e53ebddceec125bb9f10f464faa6ab1b8d0160e875c5ef6d3007",
+		},
+		{
+			params:   crypto.KDFParams{Algorithm: crypto.KDFPBKDF2SHA256, Salt: []byte("somesalt"), Time: 1000, KeyLength: 32},
+			password: "password",
+			phc:      "$pbkdf2-sha256$i=1000$c29tZXNhbHQ",
+			keyHex:   "8f801ad788a752d3a1ed283f0fb847e78a21ca6b873500f8f9c71f85ea465806",
+		},
+		{
+			params:   crypto.KDFParams{Algorithm: crypto.KDFHKDFSHA256, Salt: []byte("somesalt"), KeyLength: 32},
+			password: "some high entropy secret",
+			phc:      "$hkdf-sha256$c29tZXNhbHQ",
+		},
+	}
+	// Test cases for KDFParams.Validate
+	kdfParamsValidationTestCases = []struct {
+		name   string
+		edit   func(params *crypto.KDFParams)
+		expect error
+	}{
+		{name: "valid", edit: func(params *crypto.KDFParams) {}},
+		{name: "algorithm", edit: func(params *crypto.KDFParams) { params.Algorithm = 0 }, expect: crypto.ErrKDFAlgorithm},
+		{name: "time", edit: func(params *crypto.KDFParams) { params.Time = 0 }, expect: crypto.ErrKDFParams},
+		{name: "huge time", edit: func(params *crypto.KDFParams) { params.Time = crypto.MaxKDFTime + 1 }, expect: crypto.ErrKDFParams},
+		{name: "threads", edit: func(params *crypto.KDFParams) { params.Threads = 0 }, expect: crypto.ErrKDFParams},
+		{name: "huge threads", edit: func(params *crypto.KDFParams) { params.Threads = crypto.MaxKDFThreads + 1 }, expect: crypto.ErrKDFParams},
+		{name: "memory", edit: func(params *crypto.KDFParams) { params.Memory = 7 }, expect: crypto.ErrKDFParams},
+		{name: "huge memory", edit: func(params *crypto.KDFParams) { params.Memory = crypto.MaxKDFMemory + 1 }, expect: crypto.ErrKDFParams},
+		{name: "salt", edit: func(params *crypto.KDFParams) { params.Salt = []byte("short") }, expect: crypto.ErrKDFParams},
+		{name: "nil salt", edit: func(params *crypto.KDFParams) { params.Salt = nil }, expect: crypto.ErrKDFParams},
+		{name: "key length", edit: func(params *crypto.KDFParams) { params.KeyLength = 8 }, expect: crypto.ErrKDFParams},
+		{name: "huge key length", edit: func(params *crypto.KDFParams) { params.KeyLength = crypto.MaxKDFKeyLength + 1 }, expect: crypto.ErrKDFParams},
+		{name: "block size", edit: func(params *crypto.KDFParams) { params.BlockSize = 8 }, expect: crypto.ErrKDFParams},
+		{name: "scrypt", edit: func(params *crypto.KDFParams) { params.Algorithm = crypto.KDFScrypt }, expect: crypto.ErrKDFParams},
+		{name: "scrypt cost", edit: func(params *crypto.KDFParams) {
+			*params = crypto.KDFParams{Algorithm: crypto.KDFScrypt, Salt: params.Salt, Time: 63, BlockSize: 8, Threads: 1, KeyLength: 32}
+		}, expect: crypto.ErrKDFParams},
+		{name: "scrypt memory", edit: func(params *crypto.KDFParams) {
+			*params = crypto.KDFParams{Algorithm: crypto.KDFScrypt, Salt: params.Salt, Time: 21, BlockSize: 8, Threads: 1, KeyLength: 32}
+		}, expect: crypto.ErrKDFParams},
+		{name: "scrypt parallelism", edit: func(params *crypto.KDFParams) {
+			*params = crypto.KDFParams{Algorithm: crypto.KDFScrypt, Salt: params.Salt, Time: 10, BlockSize: 1 << 23, Threads: 128, KeyLength: 32}
+		}, expect: crypto.ErrKDFParams},
+		{name: "pbkdf2", edit: func(params *crypto.KDFParams) { params.Algorithm = crypto.KDFPBKDF2SHA256 }, expect: crypto.ErrKDFParams},
+		{name: "pbkdf2 iterations", edit: func(params *crypto.KDFParams) {
+			*params = crypto.KDFParams{Algorithm: crypto.KDFPBKDF2SHA256, Salt: params.Salt, Time: crypto.MaxPBKDF2Iterations / 2, KeyLength: 64 + 1}
+		}, expect: crypto.ErrKDFParams},
+		{name: "hkdf", edit: func(params *crypto.KDFParams) { params.Algorithm = crypto.KDFHKDFSHA256 }, expect: crypto.ErrKDFParams},
+	}
+)
+
+func ExampleDeriveKeyWithParams() {
+	params, err := crypto.ParseKDFParams("$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ")
+	if err != nil {
+		panic(err)
+	}
+	key, err := crypto.DeriveKeyWithParams("somepassword", params)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("key = %x", key)
+	// Output: key = e802d7f1de82db6df4a05d0c72d09e79d599ed3776fe538bf9ddf6cc5e890925
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, testCase := range kdfParamsValidationTestCases {
+		params := sampleKDFParams.Clone()
+		testCase.edit(&params)
+		if err := params.Validate(); !errors.Is(err, testCase.expect) || (err == nil) != (testCase.expect == nil) {
+			t.Fatalf("expected %v validating %s params, instead got %v", testCase.expect, testCase.name, err)
+		}
+		if _, err := crypto.DeriveKeyWithParams("password", params); (err == nil) != (testCase.expect == nil) {
+			t.Fatalf("expected %v deriving with %s params, instead got %v", testCase.expect, testCase.name, err)
+		}
+	}
+	// DeriveKeyWithParams requires an AES key length
+	params := sampleKDFParams.Clone()
+	params.KeyLength = 16
+	if _, err := crypto.DeriveKeyWithParams("password", params); err != crypto.ErrKDFKeyLength {
+		t.Fatalf("expected ErrKDFKeyLength, instead got %v", err)
+	}
+}
+
+func TestKDFAlgorithms(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, testCase := range kdfAlgorithmTestCases {
+		if err := testCase.params.Validate(); err != nil {
+			t.Fatalf("%v params are invalid: %v", testCase.params.Algorithm, err)
+		}
+		// PHC encoding
+		if phc := testCase.params.String(); phc != testCase.phc {
+			t.Fatalf("expected %q, instead got %q", testCase.phc, phc)
+		}
+		parsed, err := crypto.ParseKDFParams(testCase.phc)
+		if err != nil || !equalKDFParams(parsed, testCase.params) {
+			t.Fatalf("failed to parse %q: got %+v (%v)", testCase.phc, parsed, err)
+		}
+		// Binary encoding
+		data, err := testCase.params.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to encode %v params: %v", testCase.params.Algorithm, err)
+		}
+		decoded := crypto.KDFParams{}
+		if err = decoded.UnmarshalBinary(data); err != nil || !equalKDFParams(decoded, testCase.params) {
+			t.Fatalf("failed to decode %v params: got %+v (%v)", testCase.params.Algorithm, decoded, err)
+		}
+		// Defaults
+		defaults, err := crypto.RecommendedKDFParamsFor(testCase.params.Algorithm)
+		if err != nil {
+			t.Fatalf("failed to get the %v defaults: %v", testCase.params.Algorithm, err)
+		}
+		defaults.Salt = make([]byte, crypto.KDFSaltSize)
+		if err = defaults.Validate(); err != nil {
+			t.Fatalf("the %v defaults are invalid: %v", testCase.params.Algorithm, err)
+		}
+		if testCase.params.Algorithm == crypto.KDFHKDFSHA256 {
+			// Not usable with passwords
+			if _, err = crypto.DeriveKeyWithParams(testCase.password, testCase.params); !errors.Is(err, crypto.ErrKDFNotForPasswords) {
+				t.Fatalf("expected ErrKDFNotForPasswords deriving a key, instead got %v", err)
+			}
+			if _, err = crypto.HashPasswordWithParams(testCase.password, testCase.params); !errors.Is(err, crypto.ErrKDFNotForPasswords) {
+				t.Fatalf("expected ErrKDFNotForPasswords hashing a password, instead got %v", err)
+			}
+			hash := testCase.phc + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+			if _, err = crypto.VerifyPassword(testCase.password, hash); !errors.Is(err, crypto.ErrKDFNotForPasswords) {
+				t.Fatalf("expected ErrKDFNotForPasswords verifying a password, instead got %v", err)
+			}
+			continue
+		}
+		// Key derivation
+		key, err := crypto.DeriveKeyWithParams(testCase.password, testCase.params)
+		if err != nil {
+			t.Fatalf("failed to derive %v key: %v", testCase.params.Algorithm, err)
+		}
+		if actual := fmt.Sprintf("%x", key); actual != testCase.keyHex {
+			t.Fatalf("%v derived %s instead of %s", testCase.params.Algorithm, actual, testCase.keyHex)
+		}
+		// Password hashing
+		encoded, err := crypto.HashPasswordWithParams(testCase.password, testCase.params)
+		if err != nil {
+			t.Fatalf("failed to hash password with %v: %v", testCase.params.Algorithm, err)
+		}
+		if ok, err := crypto.VerifyPassword(testCase.password, encoded); !ok || err != nil {
+			t.Fatalf("failed to verify %q (%v)", encoded, err)
+		}
+	}
+	if _, err := crypto.RecommendedKDFParamsFor(0); !errors.Is(err, crypto.ErrKDFAlgorithm) {
+		t.Fatalf("expected ErrKDFAlgorithm, instead got %v", err)
+	}
+}
+
+func TestKDFParamsPolicy(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	if err := sampleKDFParams.CheckPolicy(crypto.DefaultKDFPolicy); !errors.Is(err, crypto.ErrKDFTooWeak) {
+		t.Fatalf("expected the sample params to be too weak, instead got %v", err)
+	}
+	params, err := crypto.NewKDFParams()
+	if err != nil {
+		t.Fatalf("failed to generate params: %v", err)
+	}
+	if err = params.CheckPolicy(crypto.DefaultKDFPolicy); err != nil {
+		t.Fatalf("expected the recommended params to meet the default policy, instead got %v", err)
+	}
+	params.Salt = params.Salt[:crypto.MinKDFSaltSize]
+	if err = params.CheckPolicy(crypto.DefaultKDFPolicy); !errors.Is(err, crypto.ErrKDFTooWeak) {
+		t.Fatalf("expected a short salt to be too weak, instead got %v", err)
+	}
+	if err = (crypto.KDFParams{}).CheckPolicy(crypto.KDFPolicy{}); !errors.Is(err, crypto.ErrKDFAlgorithm) {
+		t.Fatalf("expected invalid params to fail validation, instead got %v", err)
+	}
+	// Other algorithms
+	for _, testCase := range kdfAlgorithmTestCases {
+		if err = testCase.params.CheckPolicy(crypto.DefaultKDFPolicy); !errors.Is(err, crypto.ErrKDFTooWeak) {
+			t.Fatalf("expected the %v test params to be too weak, instead got %v", testCase.params.Algorithm, err)
+		}
+		params, err := crypto.RecommendedKDFParamsFor(testCase.params.Algorithm)
+		if err != nil {
+			t.Fatalf("failed to get the %v defaults: %v", testCase.params.Algorithm, err)
+		}
+		params.Salt = make([]byte, crypto.KDFSaltSize)
+		err = params.CheckPolicy(crypto.DefaultKDFPolicy)
+		if params.Algorithm == crypto.KDFHKDFSHA256 {
+			if !errors.Is(err, crypto.ErrKDFTooWeak) {
+				t.Fatalf("expected hkdf to be rejected by the default policy, instead got %v", err)
+			}
+			policy := crypto.DefaultKDFPolicy
+			policy.AllowHKDF = true
+			err = params.CheckPolicy(policy)
+		}
+		if err != nil {
+			t.Fatalf("expected the %v defaults to meet the policy, instead got %v", params.Algorithm, err)
+		}
+	}
+}
+
+func TestKDFParamsEncoding(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Binary
+	data, err := sampleKDFParams.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode params: %v", err)
+	}
+	decoded := crypto.KDFParams{}
+	if err = decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to decode params: %v", err)
+	}
+	if !equalKDFParams(decoded, sampleKDFParams) {
+		t.Fatalf("decoded params %+v don't match %+v", decoded, sampleKDFParams)
+	}
+	for i := range data {
+		if err = decoded.UnmarshalBinary(data[:i]); err == nil {
+			t.Fatalf("expected an error decoding %d truncated bytes", i)
+		}
+	}
+	if err = decoded.UnmarshalBinary(append(data, 0)); err != crypto.ErrKDFEncoding {
+		t.Fatalf("expected ErrKDFEncoding with trailing data, instead got %v", err)
+	}
+	// Version, algorithm, time, memory, threads, block size, key
+	// length, salt length and salt
+	if expected := "01" + "01" + "00000003" + "00001000" + "01" + "00000000" + "00000020" + "0008" + "736f6d6573616c74"; hex.EncodeToString(data) != expected {
+		t.Fatalf("expected %s, instead got %x", expected, data)
+	}
+	if err = decoded.UnmarshalBinary(append([]byte{2}, data[1:]...)); err != crypto.ErrKDFEncoding {
+		t.Fatalf("expected ErrKDFEncoding with an unknown version, instead got %v", err)
+	}
+	// PHC
+	if phc := sampleKDFParams.String(); phc != "$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ" {
+		t.Fatalf("unexpected PHC string %q", phc)
+	}
+	decoded, err = crypto.ParseKDFParams(samplePHC)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", samplePHC, err)
+	}
+	if !equalKDFParams(decoded, sampleKDFParams) {
+		t.Fatalf("parsed params %+v don't match %+v", decoded, sampleKDFParams)
+	}
+	for _, invalid := range []string{
+		"",
+		"argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ",
+		"$argon2i$v=19$m=4096,t=3,p=1$c29tZXNhbHQ",
+		"$argon2id$v=16$m=4096,t=3,p=1$c29tZXNhbHQ",
+		"$argon2id$m=4096,t=3,p=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3,p=1,m=4096$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3,p=256$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3,p=1,x=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ=",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$$",
+		"$scrypt$v=19$ln=10,r=8,p=1$c29tZXNhbHQ",
+		"$scrypt$ln=10,r=8$c29tZXNhbHQ",
+		"$scrypt$ln=10,r=8,p=1,i=1$c29tZXNhbHQ",
+		"$scrypt$ln=10,r=8,p=256$c29tZXNhbHQ",
+		"$pbkdf2-sha256$i=0$c29tZXNhbHQ",
+		"$pbkdf2-sha256$c29tZXNhbHQ",
+		"$hkdf-sha256$i=1$c29tZXNhbHQ",
+	} {
+		if _, err = crypto.ParseKDFParams(invalid); err == nil {
+			t.Fatalf("expected an error parsing %q", invalid)
+		}
+	}
+	// JSON (through MarshalText)
+	encoded, err := json.Marshal(sampleKDFParams)
+	if err != nil {
+		t.Fatalf("failed to marshal params to JSON: %v", err)
+	}
+	decoded = crypto.KDFParams{}
+	if err = json.Unmarshal(encoded, &decoded); err != nil || !equalKDFParams(decoded, sampleKDFParams) {
+		t.Fatalf("failed to unmarshal params from JSON %s: got %+v (%v)", encoded, decoded, err)
+	}
+}
+
+func TestSecureKeyParams(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	params := crypto.SecureKeyParams(nil, 0, 0, 0)
+	if !bytes.Equal(params.Salt, crypto.DefaultSalt) || params.Time != crypto.DefaultTime || params.Memory != crypto.DefaultMemory || params.Threads != crypto.DefaultParallelism {
+		t.Fatalf("unexpected default parameters %+v", params)
+	}
+	params = crypto.SecureKeyParams(sampleKDFParams.Salt, sampleKDFParams.Time, sampleKDFParams.Memory, sampleKDFParams.Threads)
+	if !equalKDFParams(params, sampleKDFParams) {
+		t.Fatalf("parameters %+v don't match %+v", params, sampleKDFParams)
+	}
+	key, err := crypto.DeriveKeyWithParams("somepassword", params)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if expected := crypto.DeriveSecureKey("somepassword", params.Salt, params.Time, params.Memory, params.Threads); key != expected {
+		t.Fatalf("DeriveKeyWithParams (%x) and DeriveSecureKey (%x) don't match", key, expected)
+	}
+}
+
+// Returns true if two sets of parameters are the same
+func equalKDFParams(a crypto.KDFParams, b crypto.KDFParams) bool {
+	return a.Algorithm == b.Algorithm && bytes.Equal(a.Salt, b.Salt) && a.Time == b.Time && a.Memory == b.Memory && a.Threads == b.Threads && a.BlockSize == b.BlockSize && a.KeyLength == b.KeyLength
+}
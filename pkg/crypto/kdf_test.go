@@ -0,0 +1,69 @@
+package crypto_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestRecommendedKDFParams(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	params := crypto.RecommendedKDFParams()
+	if params.Time != crypto.DefaultTime || params.Memory != crypto.DefaultMemory {
+		t.Fatalf("expected the default time and memory, instead got %+v", params)
+	}
+	if params.Threads < 1 || params.Threads > crypto.MaxRecommendedParallelism || int(params.Threads) > runtime.NumCPU() {
+		t.Fatalf("unexpected number of threads %d with %d CPUs", params.Threads, runtime.NumCPU())
+	}
+}
+
+func TestCalibrateKDF(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Invalid inputs
+	if _, err := crypto.CalibrateKDF(0, crypto.MinCalibrationMemory); err != crypto.ErrCalibrationDuration {
+		t.Fatalf("expected ErrCalibrationDuration, instead got %v", err)
+	}
+	if _, err := crypto.CalibrateKDF(time.Second, crypto.MinCalibrationMemory-1); err != crypto.ErrCalibrationMemory {
+		t.Fatalf("expected ErrCalibrationMemory, instead got %v", err)
+	}
+	// A short target that should still give usable parameters
+	maxMemory := 2 * crypto.MinCalibrationMemory
+	params, err := crypto.CalibrateKDF(50*time.Millisecond, maxMemory)
+	if err != nil {
+		t.Fatalf("failed to calibrate: %v", err)
+	}
+	t.Logf("calibrated parameters: %+v", params)
+	if params.Time < 1 || params.Threads < 1 {
+		t.Fatalf("calibrated parameters %+v are not usable", params)
+	}
+	if params.Memory < crypto.MinCalibrationMemory || params.Memory > maxMemory {
+		t.Fatalf("calibrated memory %d is outside of [%d, %d]", params.Memory, crypto.MinCalibrationMemory, maxMemory)
+	}
+	// A derivation takes about the target duration (generously, as
+	// other tests run at the same time)
+	if !testing.Short() {
+		target := 200 * time.Millisecond
+		params, err := crypto.CalibrateKDF(target, maxMemory)
+		if err != nil {
+			t.Fatalf("failed to calibrate: %v", err)
+		}
+		params.Salt = []byte("somesalt")
+		start := time.Now()
+		if _, err = crypto.DeriveKeyWithParams("password", params); err != nil {
+			t.Fatalf("failed to derive key with %+v: %v", params, err)
+		}
+		if elapsed := time.Since(start); elapsed < target/5 || elapsed > 5*target {
+			t.Fatalf("expected a derivation with %+v to take about %v, instead took %v", params, target, elapsed)
+		}
+	}
+	// A tiny target that can't be met still returns the minimums
+	params, err = crypto.CalibrateKDF(time.Nanosecond, maxMemory)
+	if err != nil {
+		t.Fatalf("failed to calibrate: %v", err)
+	}
+	if params.Time != 1 || params.Memory != crypto.MinCalibrationMemory {
+		t.Fatalf("expected a single pass with the minimum memory, instead got %+v", params)
+	}
+}
@@ -0,0 +1,224 @@
+package crypto
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// Error returned when a derivation needs more memory than the
+	// whole budget of a `KeyDeriver`
+	ErrDeriverBudget = errors.New("key deriver: the derivation needs more memory than the budget")
+)
+
+// Runs key derivations while bounding the memory they use and how
+// many run at once, so that a burst of requests (for example on a
+// login endpoint) queues up instead of exhausting memory.
+//
+// Derivations are admitted in the order they arrive. A KeyDeriver
+// is safe for concurrent use and must not be copied.
+type KeyDeriver struct {
+	// The memory budget (in KiB), or 0 for no limit
+	memoryBudget uint64
+	// The maximum number of concurrent derivations, or 0 for no limit
+	maxConcurrent int
+
+	// Guards the fields below
+	lock sync.Mutex
+	// The memory (in KiB) used by running derivations
+	memoryInUse uint64
+	// The number of running derivations
+	running int
+	// The derivations waiting to run (*deriverWaiter)
+	waiters list.List
+	// The counters reported by `Stats`
+	completed    uint64
+	canceled     uint64
+	maxWaiting   int
+	totalWaiting time.Duration
+}
+
+// A snapshot of the state of a `KeyDeriver`
+type KeyDeriverStats struct {
+	// The memory budget (in KiB), or 0 for no limit
+	MemoryBudget uint64
+	// The memory (in KiB) used by running derivations
+	MemoryInUse uint64
+	// The maximum number of concurrent derivations, or 0 for no limit
+	MaxConcurrent int
+	// The number of running derivations
+	Running int
+	// The number of derivations waiting to run (the queue depth)
+	Waiting int
+	// The largest queue depth seen so far
+	MaxWaiting int
+	// The number of derivations that ran
+	Completed uint64
+	// The number of derivations whose context ended before they ran
+	Canceled uint64
+	// The total time derivations spent waiting to run
+	TotalWaiting time.Duration
+}
+
+// A derivation waiting for its turn
+type deriverWaiter struct {
+	// The memory (in KiB) the derivation needs
+	memory uint64
+	// Closed once the derivation may run
+	ready chan struct{}
+}
+
+// Creates a `KeyDeriver` running derivations that use at most
+// memoryBudget KiB in total, and at most maxConcurrent at once.
+// A value of 0 disables the corresponding limit.
+//
+// For example, with the default Argon2id parameters (124 MiB)
+// a budget of 512 MiB allows 4 concurrent derivations.
+func NewKeyDeriver(memoryBudget uint64, maxConcurrent int) *KeyDeriver {
+	return &KeyDeriver{
+		memoryBudget:  memoryBudget,
+		maxConcurrent: max(maxConcurrent, 0),
+	}
+}
+
+// Derives a key from a password as described by params (like
+// `DeriveKeyWithParams`), waiting until the derivation fits within
+// the limits of the `KeyDeriver`.
+//
+// If ctx ends before or while waiting, its error is returned. A
+// derivation that started is not interrupted.
+func (deriver *KeyDeriver) DeriveContext(ctx context.Context, password string, params KDFParams) (key AESKey, err error) {
+	if err = params.Validate(); err != nil {
+		return
+	}
+	memory := params.MemoryCost()
+	if deriver.memoryBudget != 0 && memory > deriver.memoryBudget {
+		err = fmt.Errorf("%w: %d KiB needed, %d KiB available", ErrDeriverBudget, memory, deriver.memoryBudget)
+		return
+	}
+	if err = deriver.acquire(ctx, memory); err != nil {
+		return
+	}
+	defer deriver.release(memory)
+	return DeriveKeyWithParams(password, params)
+}
+
+// Securely derives a key like `DeriveSecureKey`, waiting until the
+// derivation fits within the limits of the `KeyDeriver` (see
+// `DeriveContext`)
+func (deriver *KeyDeriver) DeriveSecureKeyContext(ctx context.Context, password string, salt []byte, time uint32, memory uint32, threads uint8) (key AESKey, err error) {
+	return deriver.DeriveContext(ctx, password, SecureKeyParams(salt, time, memory, threads))
+}
+
+// Returns a snapshot of the state of the `KeyDeriver`
+func (deriver *KeyDeriver) Stats() KeyDeriverStats {
+	deriver.lock.Lock()
+	defer deriver.lock.Unlock()
+	return KeyDeriverStats{
+		MemoryBudget:  deriver.memoryBudget,
+		MemoryInUse:   deriver.memoryInUse,
+		MaxConcurrent: deriver.maxConcurrent,
+		Running:       deriver.running,
+		Waiting:       deriver.waiters.Len(),
+		MaxWaiting:    deriver.maxWaiting,
+		Completed:     deriver.completed,
+		Canceled:      deriver.canceled,
+		TotalWaiting:  deriver.totalWaiting,
+	}
+}
+
+// Waits until a derivation using memory KiB can run, or ctx ends
+func (deriver *KeyDeriver) acquire(ctx context.Context, memory uint64) error {
+	deriver.lock.Lock()
+	if err := ctx.Err(); err != nil {
+		deriver.canceled++
+		deriver.lock.Unlock()
+		return err
+	}
+	// Only skip the queue if nobody is waiting, to keep the order
+	if deriver.waiters.Len() == 0 && deriver.fits(memory) {
+		deriver.start(memory)
+		deriver.lock.Unlock()
+		return nil
+	}
+	waiter := &deriverWaiter{memory: memory, ready: make(chan struct{})}
+	element := deriver.waiters.PushBack(waiter)
+	deriver.maxWaiting = max(deriver.maxWaiting, deriver.waiters.Len())
+	deriver.lock.Unlock()
+
+	start := time.Now()
+	select {
+	case <-waiter.ready:
+		deriver.lock.Lock()
+		deriver.totalWaiting += time.Since(start)
+		deriver.lock.Unlock()
+		return nil
+	case <-ctx.Done():
+		deriver.lock.Lock()
+		defer deriver.lock.Unlock()
+		deriver.totalWaiting += time.Since(start)
+		select {
+		case <-waiter.ready:
+			// Admitted at the same time: give the slot back
+			deriver.finish(memory, false)
+		default:
+			deriver.waiters.Remove(element)
+			// The next waiters might fit now
+			deriver.admit()
+		}
+		deriver.canceled++
+		return ctx.Err()
+	}
+}
+
+// Marks a derivation using memory KiB as done
+func (deriver *KeyDeriver) release(memory uint64) {
+	deriver.lock.Lock()
+	defer deriver.lock.Unlock()
+	deriver.finish(memory, true)
+}
+
+// Frees the resources of a running derivation and admits waiters.
+// Must be called with the lock held.
+func (deriver *KeyDeriver) finish(memory uint64, completed bool) {
+	deriver.memoryInUse -= memory
+	deriver.running--
+	if completed {
+		deriver.completed++
+	}
+	deriver.admit()
+}
+
+// Starts waiting derivations, in order, while they fit.
+// Must be called with the lock held.
+func (deriver *KeyDeriver) admit() {
+	for element := deriver.waiters.Front(); element != nil; element = deriver.waiters.Front() {
+		waiter := element.Value.(*deriverWaiter)
+		if !deriver.fits(waiter.memory) {
+			return
+		}
+		deriver.waiters.Remove(element)
+		deriver.start(waiter.memory)
+		close(waiter.ready)
+	}
+}
+
+// Returns true if a derivation using memory KiB can start now.
+// Must be called with the lock held.
+func (deriver *KeyDeriver) fits(memory uint64) bool {
+	if deriver.maxConcurrent != 0 && deriver.running >= deriver.maxConcurrent {
+		return false
+	}
+	return deriver.memoryBudget == 0 || deriver.memoryInUse+memory <= deriver.memoryBudget
+}
+
+// Reserves the resources of a derivation using memory KiB.
+// Must be called with the lock held.
+func (deriver *KeyDeriver) start(memory uint64) {
+	deriver.memoryInUse += memory
+	deriver.running++
+}
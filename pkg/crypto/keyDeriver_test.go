@@ -0,0 +1,119 @@
+package crypto_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestKeyDeriver(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	params := sampleKDFParams.Clone()
+	expected, err := crypto.DeriveKeyWithParams("password", params)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	// Room for two derivations at once
+	deriver := crypto.NewKeyDeriver(2*params.MemoryCost(), 0)
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := deriver.DeriveContext(context.Background(), "password", params)
+			if err == nil && key != expected {
+				err = errors.New("unexpected key")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to derive key: %v", err)
+		}
+	}
+	stats := deriver.Stats()
+	if stats.Completed != 16 || stats.Running != 0 || stats.Waiting != 0 || stats.MemoryInUse != 0 || stats.Canceled != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// Too much memory for the budget
+	params.Memory *= 4
+	if _, err := deriver.DeriveContext(context.Background(), "password", params); !errors.Is(err, crypto.ErrDeriverBudget) {
+		t.Fatalf("expected ErrDeriverBudget, instead got %v", err)
+	}
+	// Invalid parameters
+	params.Time = 0
+	if _, err := deriver.DeriveContext(context.Background(), "password", params); !errors.Is(err, crypto.ErrKDFParams) {
+		t.Fatalf("expected ErrKDFParams, instead got %v", err)
+	}
+}
+
+func TestKeyDeriverContext(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	deriver := crypto.NewKeyDeriver(0, 1)
+	// A canceled context doesn't derive, even with a free slot
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := deriver.DeriveContext(canceled, "password", sampleKDFParams); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+	if stats := deriver.Stats(); stats.Completed != 0 || stats.Canceled != 1 {
+		t.Fatalf("expected no derivation, instead got %+v", stats)
+	}
+	// Keep the only slot busy with a slow derivation
+	done := make(chan error)
+	go func() {
+		_, err := deriver.DeriveSecureKeyContext(context.Background(), "password", []byte("somesalt"), 8, 32*1024, 1)
+		done <- err
+	}()
+	for deriver.Stats().Running == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// A canceled caller gives up its place in the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := deriver.DeriveContext(ctx, "password", sampleKDFParams); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+	// A waiting caller runs once the slot is free
+	waiting := make(chan error)
+	go func() {
+		_, err := deriver.DeriveContext(context.Background(), "password", sampleKDFParams)
+		waiting <- err
+	}()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if err := <-waiting; err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	stats := deriver.Stats()
+	if stats.Completed != 2 || stats.Canceled != 2 || stats.MaxWaiting < 1 || stats.Running != 0 || stats.Waiting != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestKDFParamsMemoryCost(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for _, testCase := range []struct {
+		params crypto.KDFParams
+		cost   uint64
+	}{
+		{crypto.KDFParams{Algorithm: crypto.KDFArgon2id, Memory: 19 * 1024}, 19 * 1024},
+		// 128 * 8 * 2^17 + 128 * 8 * 1 + 256 * 8 bytes
+		{crypto.KDFParams{Algorithm: crypto.KDFScrypt, Time: 17, BlockSize: 8, Threads: 1}, 128*1024 + 3},
+		{crypto.KDFParams{Algorithm: crypto.KDFPBKDF2SHA256, Time: 600_000}, 0},
+		{crypto.KDFParams{Algorithm: crypto.KDFHKDFSHA256}, 0},
+	} {
+		if cost := testCase.params.MemoryCost(); cost != testCase.cost {
+			t.Fatalf("expected a cost of %d KiB for %v, instead got %d", testCase.cost, testCase.params.Algorithm, cost)
+		}
+	}
+}
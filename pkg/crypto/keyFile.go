@@ -0,0 +1,177 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+const (
+	// The current version of the key file format
+	KeyFileVersion uint8 = 1
+	// The size of the salt generated for new key files
+	KeyFileSaltSize = 32
+	// The permissions used when writing a key file
+	KeyFilePermissions os.FileMode = 0o600
+	// The largest Argon2id time accepted when loading a key file
+	MaxKeyFileTime uint32 = 64
+	// The largest Argon2id memory (in KiB) accepted when loading a
+	// key file
+	MaxKeyFileMemory uint32 = 1024 * 1024 // 1 GiB
+)
+
+var (
+	// The magic bytes every key file starts with
+	keyFileMagic = []byte("GSKF")
+)
+
+var (
+	// Error returned when a key file is malformed or truncated
+	ErrKeyFileFormat = errors.New("key file: invalid format")
+	// Error returned when a key file uses an unsupported version
+	ErrKeyFileVersion = errors.New("key file: unsupported version")
+	// Error returned when a key file is readable or writable by
+	// the group or by others
+	ErrKeyFilePermissions = errors.New("key file: insecure permissions (the file must not be accessible by group or others)")
+	// Error returned when the key file cannot be unlocked, either
+	// because the passphrase is wrong or the file was tampered with
+	ErrKeyFilePassphrase = errors.New("key file: wrong passphrase or corrupted file")
+)
+
+// The header of a key file, storing the parameters used to derive
+// the key-encryption key (KEK) from the passphrase
+type keyFileHeader struct {
+	// Argon2id time parameter
+	time uint32
+	// Argon2id memory parameter (in KiB)
+	memory uint32
+	// Argon2id threads parameter
+	threads uint8
+	// Salt used for the derivation
+	salt []byte
+}
+
+// Saves a key to path, encrypted with a key derived from passphrase.
+//
+// The key-encryption key is derived with Argon2id using the default
+// parameters and a fresh random salt, both of which are recorded in
+// the file. The file is written atomically and is only readable by
+// its owner.
+func SaveKeyFile(path string, key AESKey, passphrase string) (err error) {
+	header := keyFileHeader{
+		time:    DefaultTime,
+		memory:  DefaultMemory,
+		threads: DefaultParallelism,
+		salt:    make([]byte, KeyFileSaltSize),
+	}
+	if _, err = io.ReadFull(rand.Reader, header.salt); err != nil {
+		return
+	}
+	data, err := sealKeyFile(header, key, passphrase)
+	if err != nil {
+		return
+	}
+	return helpers.WriteFileAtomic(path, data, KeyFilePermissions)
+}
+
+// Loads a key from a file created by `SaveKeyFile`.
+//
+// Refuses to read files that are accessible by group or others, and
+// files with Argon2id parameters above `MaxKeyFileTime` and
+// `MaxKeyFileMemory` (so that a tampered file can't exhaust the
+// memory or the CPU).
+func LoadKeyFile(path string, passphrase string) (key AESKey, err error) {
+	data, err := readPrivateFile(path)
+	if err != nil {
+		return
+	}
+	return openKeyFile(data, passphrase)
+}
+
+// Re-encrypts the key stored at path under a new passphrase.
+//
+// The key itself does not change; a fresh salt is generated and the
+// current default parameters are used for the new key-encryption key.
+func ChangePassphrase(path string, oldPassphrase string, newPassphrase string) (err error) {
+	key, err := LoadKeyFile(path, oldPassphrase)
+	if err != nil {
+		return
+	}
+	return SaveKeyFile(path, key, newPassphrase)
+}
+
+// Encodes and encrypts a key file (the header is authenticated with
+// the key)
+func sealKeyFile(header keyFileHeader, key AESKey, passphrase string) (data []byte, err error) {
+	kek := helpers.DeriveKeySecure(passphrase, header.salt, header.time, header.memory, header.threads)
+	data = append(data, keyFileMagic...)
+	data = append(data, KeyFileVersion)
+	data = binary.BigEndian.AppendUint32(data, header.time)
+	data = binary.BigEndian.AppendUint32(data, header.memory)
+	data = append(data, header.threads, uint8(len(header.salt)))
+	data = append(data, header.salt...)
+	return helpers.SealWithData(helpers.NewAEAD(kek), rand.Reader, data, key[:], data)
+}
+
+// Decodes and decrypts a key file
+func openKeyFile(data []byte, passphrase string) (key AESKey, err error) {
+	// Magic (4) + Version (1) + Time (4) + Memory (4) + Threads (1) + Salt length (1)
+	const fixedSize = 4 + 1 + 4 + 4 + 1 + 1
+	if len(data) < fixedSize || !bytes.Equal(data[:len(keyFileMagic)], keyFileMagic) {
+		err = ErrKeyFileFormat
+		return
+	}
+	if data[4] != KeyFileVersion {
+		err = ErrKeyFileVersion
+		return
+	}
+	header := keyFileHeader{
+		time:    binary.BigEndian.Uint32(data[5:9]),
+		memory:  binary.BigEndian.Uint32(data[9:13]),
+		threads: data[13],
+	}
+	saltSize := int(data[14])
+	if header.time == 0 || header.time > MaxKeyFileTime || header.memory > MaxKeyFileMemory || header.threads == 0 || saltSize == 0 ||
+		len(data) != fixedSize+saltSize+helpers.NonceSize+helpers.KeySize+helpers.TagSize {
+		err = ErrKeyFileFormat
+		return
+	}
+	headerData := data[:fixedSize+saltSize]
+	header.salt = headerData[fixedSize:]
+	kek := helpers.DeriveKeySecure(passphrase, header.salt, header.time, header.memory, header.threads)
+	plaintext, err := helpers.OpenWithData(helpers.NewAEAD(kek), nil, data[len(headerData):], headerData)
+	if err != nil {
+		err = ErrKeyFilePassphrase
+		return
+	}
+	copy(key[:], plaintext)
+	clear(plaintext)
+	return
+}
+
+// Reads the file at path, returning an error if it is accessible by
+// group or others (the permissions are checked on the open file, so
+// it can't be replaced between the check and the read)
+func readPrivateFile(path string) (data []byte, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	// Windows does not have meaningful unix permission bits
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		err = ErrKeyFilePermissions
+		return
+	}
+	return io.ReadAll(file)
+}
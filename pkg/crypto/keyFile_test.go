@@ -0,0 +1,119 @@
+package crypto_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestKeyFile(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	path := filepath.Join(t.TempDir(), "key")
+	key := crypto.DeriveKey("some key to store")
+	// Save and load
+	if err := crypto.SaveKeyFile(path, key, "passphrase"); err != nil {
+		t.Fatalf("failed to save key file: %v", err)
+	}
+	loaded, err := crypto.LoadKeyFile(path, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to load key file: %v", err)
+	}
+	if loaded != key {
+		t.Fatalf("loaded key %x doesn't match saved key %x", loaded, key)
+	}
+	// Wrong passphrase
+	if _, err = crypto.LoadKeyFile(path, "wrong"); err != crypto.ErrKeyFilePassphrase {
+		t.Fatalf("expected ErrKeyFilePassphrase with the wrong passphrase, instead got %v", err)
+	}
+	// Change passphrase
+	if err = crypto.ChangePassphrase(path, "wrong", "new passphrase"); err != crypto.ErrKeyFilePassphrase {
+		t.Fatalf("expected ErrKeyFilePassphrase when changing passphrase with the wrong one, instead got %v", err)
+	}
+	if err = crypto.ChangePassphrase(path, "passphrase", "new passphrase"); err != nil {
+		t.Fatalf("failed to change passphrase: %v", err)
+	}
+	if _, err = crypto.LoadKeyFile(path, "passphrase"); err != crypto.ErrKeyFilePassphrase {
+		t.Fatalf("expected the old passphrase to stop working, instead got %v", err)
+	}
+	if loaded, err = crypto.LoadKeyFile(path, "new passphrase"); err != nil || loaded != key {
+		t.Fatalf("failed to load key with new passphrase: got %x (%v)", loaded, err)
+	}
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed to list key file directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the key file in the directory, found %d entries", len(entries))
+	}
+}
+
+func TestKeyFileTampering(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	path := filepath.Join(t.TempDir(), "key")
+	if err := crypto.SaveKeyFile(path, crypto.AESKey{}, "passphrase"); err != nil {
+		t.Fatalf("failed to save key file: %v", err)
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read key file: %v", err)
+	}
+	// Returns the original key file with the Argon2id memory set to memory
+	withMemory := func(memory uint32) []byte {
+		data := append([]byte{}, original...)
+		binary.BigEndian.PutUint32(data[9:13], memory)
+		return data
+	}
+	testCases := []struct {
+		name   string
+		data   []byte
+		expect error
+	}{
+		{name: "empty", data: []byte{}, expect: crypto.ErrKeyFileFormat},
+		{name: "magic", data: append([]byte("XXXX"), original[4:]...), expect: crypto.ErrKeyFileFormat},
+		{name: "version", data: append(append([]byte{}, original[:4]...), append([]byte{0xff}, original[5:]...)...), expect: crypto.ErrKeyFileVersion},
+		{name: "huge memory", data: withMemory(crypto.MaxKeyFileMemory + 1), expect: crypto.ErrKeyFileFormat},
+		{name: "header", data: withMemory(crypto.DefaultMemory - 1), expect: crypto.ErrKeyFilePassphrase},
+		{name: "truncated", data: original[:len(original)-1], expect: crypto.ErrKeyFileFormat},
+		{name: "ciphertext", data: append(append([]byte{}, original[:len(original)-1]...), original[len(original)-1]^1), expect: crypto.ErrKeyFilePassphrase},
+	}
+	for _, testCase := range testCases {
+		if err = os.WriteFile(path, testCase.data, crypto.KeyFilePermissions); err != nil {
+			t.Fatalf("failed to write %s key file: %v", testCase.name, err)
+		}
+		if _, err = crypto.LoadKeyFile(path, "passphrase"); err != testCase.expect {
+			t.Fatalf("expected %v loading the %s key file, instead got %v", testCase.expect, testCase.name, err)
+		}
+	}
+}
+
+func TestKeyFilePermissions(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	if err := crypto.SaveKeyFile(path, crypto.AESKey{}, "passphrase"); err != nil {
+		t.Fatalf("failed to save key file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat key file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != crypto.KeyFilePermissions {
+		t.Fatalf("expected key file permissions %o, instead got %o", crypto.KeyFilePermissions, perm)
+	}
+	for _, perm := range []os.FileMode{0o640, 0o604, 0o644} {
+		if err = os.Chmod(path, perm); err != nil {
+			t.Fatalf("failed to chmod key file: %v", err)
+		}
+		if _, err = crypto.LoadKeyFile(path, "passphrase"); !errors.Is(err, crypto.ErrKeyFilePermissions) {
+			t.Fatalf("expected ErrKeyFilePermissions with permissions %o, instead got %v", perm, err)
+		}
+	}
+}
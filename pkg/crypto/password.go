@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+// Hashes a password for storage, returning a PHC string such as
+// "$argon2id$v=19$m=126976,t=2,p=8$<salt>$<hash>".
+//
+// Uses `DefaultPasswordParams` with a new random salt. The result
+// can be checked with `VerifyPassword` and is compatible with other
+// Argon2 implementations using the PHC string format. Other
+// algorithms can be used with `HashPasswordWithParams`.
+func HashPassword(password string) (encoded string, err error) {
+	return HashPasswordWithParams(password, DefaultPasswordParams())
+}
+
+// Returns the parameters `HashPassword` uses (without a salt), to be
+// given to `NeedsRehash`.
+//
+// Unlike `RecommendedKDFParams`, the number of threads is fixed to
+// `DefaultParallelism`, so that the stored hashes don't depend on
+// the machine that created them.
+func DefaultPasswordParams() KDFParams {
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      DefaultTime,
+		Memory:    DefaultMemory,
+		Threads:   DefaultParallelism,
+		KeyLength: helpers.KeySize,
+	}
+}
+
+// Hashes a password for storage with the given parameters (see
+// `HashPassword`).
+//
+// If params has no salt, a new random salt is generated. HKDF, which
+// has no work factor, is rejected with `ErrKDFNotForPasswords`.
+func HashPasswordWithParams(password string, params KDFParams) (encoded string, err error) {
+	params = params.Clone()
+	if len(params.Salt) == 0 {
+		if params.Salt, err = NewSalt(); err != nil {
+			return
+		}
+	}
+	if err = params.validateForPasswords(); err != nil {
+		return
+	}
+	hash, err := deriveBytes([]byte(password), params)
+	if err != nil {
+		return
+	}
+	encoded = string(append(params.appendPHC(nil), "$"+base64.RawStdEncoding.EncodeToString(hash)...))
+	clear(hash)
+	return
+}
+
+// Checks a password against a PHC string from `HashPassword`.
+//
+// Returns an error only if the encoded string can't be parsed, or
+// uses HKDF (see `HashPasswordWithParams`); a wrong password returns
+// false. The comparison is constant-time.
+func VerifyPassword(password string, encoded string) (ok bool, err error) {
+	params, hash, err := parsePHC(encoded)
+	if err != nil {
+		return
+	}
+	if err = params.validateForPasswords(); err != nil {
+		return
+	}
+	if len(hash) == 0 {
+		err = ErrKDFEncoding
+		return
+	}
+	derived, err := deriveBytes([]byte(password), params)
+	if err != nil {
+		return
+	}
+	ok = subtle.ConstantTimeCompare(derived, hash) == 1
+	clear(derived)
+	return
+}
+
+// Returns true if a PHC string from `HashPassword` was created with
+// parameters other than currentParams (ignoring the salt), meaning
+// the password should be hashed again after a successful
+// `VerifyPassword`.
+func NeedsRehash(encoded string, currentParams KDFParams) (rehash bool, err error) {
+	params, _, err := parsePHC(encoded)
+	if err != nil {
+		return
+	}
+	rehash = params.Algorithm != currentParams.Algorithm ||
+		params.Time != currentParams.Time ||
+		params.Memory != currentParams.Memory ||
+		params.Threads != currentParams.Threads ||
+		params.BlockSize != currentParams.BlockSize ||
+		params.KeyLength != currentParams.KeyLength
+	return
+}
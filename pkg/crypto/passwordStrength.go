@@ -0,0 +1,351 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// The length under which `EstimatePasswordStrength` suggests a
+	// longer password
+	RecommendedPasswordLength = 12
+	// The number of characters `EstimatePasswordStrength` looks at
+	// (the rest don't add to the estimate), so that a long input
+	// can't make the estimate slow
+	maxAnalyzedPasswordLength = 256
+)
+
+var (
+	// Error matched (with errors.Is) by `WeakPasswordError`
+	ErrWeakPassword = errors.New("password is too weak")
+	// The default password policy: about the strength of a random
+	// 10 character alphanumeric password
+	DefaultPasswordPolicy = PasswordPolicy{
+		MinLength:  10,
+		MinEntropy: 50,
+	}
+)
+
+var (
+	// Common passwords and words, matched after undoing simple
+	// substitutions such as "p4$$w0rd"
+	commonPasswords = []string{
+		"password", "passw0rd", "qwerty", "letmein", "welcome", "admin",
+		"administrator", "login", "master", "monkey", "dragon", "shadow",
+		"sunshine", "princess", "football", "baseball", "soccer", "hockey",
+		"iloveyou", "love", "secret", "trustno1", "superman", "batman",
+		"starwars", "whatever", "freedom", "hello", "charlie", "donald",
+		"michael", "jordan", "jennifer", "hunter", "ranger", "buster",
+		"tigger", "pepper", "ginger", "cookie", "summer", "winter",
+		"spring", "autumn", "flower", "computer", "internet", "google",
+		"test", "guest", "user", "root", "default", "changeme", "access",
+		"pass", "god", "angel", "killer", "cheese", "banana", "orange",
+		"apple", "purple", "yellow", "silver", "golden", "diamond",
+		"mustang", "corvette", "ferrari", "harley", "matrix", "ninja",
+		"pokemon", "minecraft", "zaq1zaq1", "abc123", "monday", "friday",
+		"january", "august", "october", "december", "london", "paris",
+		"secure", "private", "company", "office", "money", "family",
+		"school", "nothing", "forever", "world",
+	}
+	// The set of commonPasswords
+	commonPasswordSet = map[string]bool{}
+	// The length (in characters) of the longest commonPasswords
+	maxCommonPasswordLength = 0
+	// Undoes the common character substitutions
+	substitutions = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+	// The rows of a qwerty keyboard
+	keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+)
+
+func init() {
+	for _, word := range commonPasswords {
+		commonPasswordSet[word] = true
+		maxCommonPasswordLength = max(maxCommonPasswordLength, utf8.RuneCountInString(word))
+	}
+}
+
+// An estimate of the strength of a password
+type PasswordStrength struct {
+	// The estimated entropy (in bits)
+	Entropy float64
+	// The length of the password (in characters)
+	Length int
+	// Suggestions to improve the password (empty if none)
+	Feedback []string
+}
+
+// A minimum strength for passwords
+type PasswordPolicy struct {
+	// The minimum length (in characters)
+	MinLength int
+	// The minimum estimated entropy (in bits)
+	MinEntropy float64
+}
+
+// The error returned when a password doesn't meet a `PasswordPolicy`
+type WeakPasswordError struct {
+	// The estimated strength of the password
+	Strength PasswordStrength
+	// The policy the password failed to meet
+	Policy PasswordPolicy
+}
+
+// A pattern found in a password
+type passwordMatch struct {
+	// The number of characters matched
+	length int
+	// The entropy of the matched characters
+	entropy float64
+	// The feedback for this pattern
+	feedback string
+}
+
+// Estimates the strength of a password.
+//
+// The estimate starts from the character classes used and is reduced
+// for common passwords and words (including simple substitutions),
+// keyboard patterns, sequences and repeats. It is a conservative
+// heuristic, not a guarantee.
+//
+// Only the first 256 characters are estimated: the others don't add
+// to the estimate (which is already far above any policy).
+func EstimatePasswordStrength(password string) (strength PasswordStrength) {
+	original := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	strength.Length = len(original)
+	poolEntropy := math.Log2(float64(characterPool(original)))
+	if len(lower) > maxAnalyzedPasswordLength {
+		lower = lower[:maxAnalyzedPasswordLength]
+	}
+	seen := map[string]bool{}
+	for i := 0; i < len(lower); {
+		match := bestPasswordMatch(lower, original, i, poolEntropy)
+		if match.length == 0 {
+			strength.Entropy += poolEntropy
+			i++
+			continue
+		}
+		strength.Entropy += match.entropy
+		i += match.length
+		if !seen[match.feedback] {
+			seen[match.feedback] = true
+			strength.Feedback = append(strength.Feedback, match.feedback)
+		}
+	}
+	if strength.Length < RecommendedPasswordLength {
+		strength.Feedback = append(strength.Feedback, fmt.Sprintf("use at least %d characters", RecommendedPasswordLength))
+	}
+	if characterClasses(original) < 3 {
+		strength.Feedback = append(strength.Feedback, "mix upper and lower case letters, digits and symbols")
+	}
+	return
+}
+
+// Returns an error wrapping a `*WeakPasswordError` if the password
+// doesn't meet the policy
+func (policy PasswordPolicy) Check(password string) error {
+	strength := EstimatePasswordStrength(password)
+	if strength.Length < policy.MinLength || strength.Entropy < policy.MinEntropy {
+		return &WeakPasswordError{Strength: strength, Policy: policy}
+	}
+	return nil
+}
+
+// Checks the password against the policy, then calls `DeriveKey`
+func (policy PasswordPolicy) DeriveKey(password string) (key AESKey, err error) {
+	if err = policy.Check(password); err != nil {
+		return
+	}
+	return DeriveKey(password), nil
+}
+
+// Checks the password against the policy, then calls `DeriveSecureKey`
+func (policy PasswordPolicy) DeriveSecureKey(password string, salt []byte, time uint32, memory uint32, threads uint8) (key AESKey, err error) {
+	if err = policy.Check(password); err != nil {
+		return
+	}
+	return DeriveSecureKey(password, salt, time, memory, threads), nil
+}
+
+// Checks the password against the policy, then calls `DeriveKeyWithParams`
+func (policy PasswordPolicy) DeriveKeyWithParams(password string, params KDFParams) (key AESKey, err error) {
+	if err = policy.Check(password); err != nil {
+		return
+	}
+	return DeriveKeyWithParams(password, params)
+}
+
+// Describes why the password is too weak
+func (err *WeakPasswordError) Error() string {
+	message := fmt.Sprintf("%v: estimated %.0f bits over %d characters (minimum %.0f bits over %d characters)",
+		ErrWeakPassword, err.Strength.Entropy, err.Strength.Length, err.Policy.MinEntropy, err.Policy.MinLength)
+	if len(err.Strength.Feedback) > 0 {
+		message += "; " + strings.Join(err.Strength.Feedback, "; ")
+	}
+	return message
+}
+
+// Matches `ErrWeakPassword`
+func (err *WeakPasswordError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// Returns the longest pattern starting at position i (or a zero
+// match if there is none)
+func bestPasswordMatch(lower []rune, original []rune, i int, poolEntropy float64) (best passwordMatch) {
+	for _, match := range []passwordMatch{
+		matchCommonPassword(lower, original, i),
+		matchKeyboard(lower, i),
+		matchSequence(lower, i),
+		matchRepeat(lower, i, poolEntropy),
+	} {
+		if match.length > best.length {
+			best = match
+		}
+	}
+	return
+}
+
+// Matches a common password or word
+func matchCommonPassword(lower []rune, original []rune, i int) (match passwordMatch) {
+	for end := min(len(lower), i+maxCommonPasswordLength); end-i >= 3; end-- {
+		segment := string(lower[i:end])
+		normalized := substitutions.Replace(segment)
+		if !commonPasswordSet[segment] && !commonPasswordSet[normalized] {
+			continue
+		}
+		// One guess per word, doubled for substitutions and capitalization
+		match.length = end - i
+		match.entropy = math.Log2(float64(len(commonPasswords)))
+		if normalized != segment {
+			match.entropy++
+		}
+		if string(original[i:end]) != segment {
+			match.entropy++
+		}
+		match.feedback = "avoid common passwords and words"
+		return
+	}
+	return
+}
+
+// Matches 3 or more adjacent keys on a keyboard row (in either direction)
+func matchKeyboard(lower []rune, i int) (match passwordMatch) {
+	for _, row := range keyboardRows {
+		for _, step := range []int{1, -1} {
+			length := 1
+			for ; i+length < len(lower); length++ {
+				previous := strings.IndexRune(row, lower[i+length-1])
+				if previous < 0 || strings.IndexRune(row, lower[i+length]) != previous+step {
+					break
+				}
+			}
+			if length >= 3 && length > match.length {
+				match.length = length
+				// The starting key, the direction and the length
+				match.entropy = math.Log2(float64(len(row))) + 1 + math.Log2(float64(length))
+				match.feedback = "avoid keyboard patterns such as \"qwerty\""
+			}
+		}
+	}
+	return
+}
+
+// Matches 3 or more consecutive characters such as "abc" or "987"
+func matchSequence(lower []rune, i int) (match passwordMatch) {
+	if i+1 >= len(lower) {
+		return
+	}
+	step := lower[i+1] - lower[i]
+	if step != 1 && step != -1 {
+		return
+	}
+	length := 2
+	for i+length < len(lower) && lower[i+length]-lower[i+length-1] == step {
+		length++
+	}
+	if length >= 3 {
+		match.length = length
+		// The starting character, the direction and the length
+		match.entropy = math.Log2(26) + 1 + math.Log2(float64(length))
+		match.feedback = "avoid sequences such as \"abc\" or \"123\""
+	}
+	return
+}
+
+// Matches a character or a group of characters repeated 3 or more
+// times (such as "aaa" or "abab")
+func matchRepeat(lower []rune, i int, poolEntropy float64) (match passwordMatch) {
+	for unit := 1; i+2*unit <= len(lower); unit++ {
+		count := 1
+		for i+(count+1)*unit <= len(lower) && slices.Equal(lower[i+count*unit:i+(count+1)*unit], lower[i:i+unit]) {
+			count++
+		}
+		if count < 2 || count*unit < 3 || count*unit <= match.length {
+			continue
+		}
+		match.length = count * unit
+		// The repeated characters and the number of repeats
+		match.entropy = float64(unit)*poolEntropy + math.Log2(float64(count))
+		match.feedback = "avoid repeated characters and patterns"
+	}
+	return
+}
+
+// Returns the size of the set of characters the password is likely
+// drawn from
+func characterPool(password []rune) (pool int) {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	return max(pool, 1)
+}
+
+// Returns the number of character classes (lower, upper, digits,
+// others) used in the password
+func characterClasses(password []rune) (classes int) {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			classes++
+		}
+	}
+	return
+}
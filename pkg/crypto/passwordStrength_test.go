@@ -0,0 +1,103 @@
+package crypto_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	testCases := []struct {
+		password   string
+		maxEntropy float64
+		minEntropy float64
+		feedback   string
+	}{
+		{"", 0, 0, "use at least"},
+		{"password", 10, 0, "common passwords"},
+		{"P4$$w0rd", 10, 0, "common passwords"},
+		{"qwertyuiop", 15, 0, "keyboard patterns"},
+		{"abcdefghijkl", 15, 0, "sequences"},
+		{"zyxwvutsrq", 15, 0, "sequences"},
+		{"aaaaaaaaaaaaaaaa", 15, 0, "repeated characters"},
+		{"xkcdxkcdxkcdxkcd", 25, 0, "repeated characters"},
+		{"Tr0ub4dor&3", 80, 40, "use at least"},
+		{"correct horse battery staple", 200, 100, ""},
+		{"h7#Kq9!vZ2@mW4pL", 200, 90, ""},
+	}
+	for _, testCase := range testCases {
+		strength := crypto.EstimatePasswordStrength(testCase.password)
+		if strength.Entropy > testCase.maxEntropy || strength.Entropy < testCase.minEntropy {
+			t.Errorf("expected %q to have between %.0f and %.0f bits, instead got %.1f",
+				testCase.password, testCase.minEntropy, testCase.maxEntropy, strength.Entropy)
+		}
+		if strength.Length != len([]rune(testCase.password)) {
+			t.Errorf("expected %q to have length %d, instead got %d", testCase.password, len([]rune(testCase.password)), strength.Length)
+		}
+		feedback := strings.Join(strength.Feedback, "\n")
+		if testCase.feedback != "" && !strings.Contains(feedback, testCase.feedback) {
+			t.Errorf("expected feedback for %q to mention %q, instead got %q", testCase.password, testCase.feedback, feedback)
+		}
+	}
+	// Longer random passwords are stronger
+	short := crypto.EstimatePasswordStrength("h7#Kq9!v")
+	long := crypto.EstimatePasswordStrength("h7#Kq9!vZ2@mW4pL")
+	if short.Entropy >= long.Entropy {
+		t.Errorf("expected a longer password to be stronger (%.1f >= %.1f)", short.Entropy, long.Entropy)
+	}
+	// Only the start of very long passwords is estimated
+	huge := crypto.EstimatePasswordStrength(strings.Repeat("h7#Kq9!vZ2@mW4pL", 1<<16))
+	if huge.Length != 1<<20 || huge.Entropy >= 256*8 {
+		t.Errorf("expected %d characters and less than %d bits, instead got %d and %.1f", 1<<20, 256*8, huge.Length, huge.Entropy)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	policy := crypto.DefaultPasswordPolicy
+	// Weak passwords
+	for _, password := range []string{"", "password1", "qwerty123456", "Tr0ub4dor"} {
+		err := policy.Check(password)
+		var weakErr *crypto.WeakPasswordError
+		if !errors.As(err, &weakErr) || !errors.Is(err, crypto.ErrWeakPassword) {
+			t.Fatalf("expected a WeakPasswordError for %q, instead got %v", password, err)
+		}
+		if weakErr.Policy != policy || weakErr.Strength.Length != len(password) {
+			t.Fatalf("unexpected error details for %q: %+v", password, weakErr)
+		}
+		if _, err := policy.DeriveKey(password); !errors.Is(err, crypto.ErrWeakPassword) {
+			t.Fatalf("expected DeriveKey to reject %q, instead got %v", password, err)
+		}
+		if _, err := policy.DeriveSecureKey(password, []byte("somesalt"), 1, 64, 1); !errors.Is(err, crypto.ErrWeakPassword) {
+			t.Fatalf("expected DeriveSecureKey to reject %q, instead got %v", password, err)
+		}
+		if _, err := policy.DeriveKeyWithParams(password, sampleKDFParams); !errors.Is(err, crypto.ErrWeakPassword) {
+			t.Fatalf("expected DeriveKeyWithParams to reject %q, instead got %v", password, err)
+		}
+	}
+	// Strong passwords derive the same keys as without a policy
+	password := "correct horse battery staple"
+	if err := policy.Check(password); err != nil {
+		t.Fatalf("expected %q to be accepted, instead got %v", password, err)
+	}
+	if key, err := policy.DeriveKey(password); err != nil || key != crypto.DeriveKey(password) {
+		t.Fatalf("unexpected DeriveKey result (%v)", err)
+	}
+	if key, err := policy.DeriveSecureKey(password, []byte("somesalt"), 1, 64, 1); err != nil || key != crypto.DeriveSecureKey(password, []byte("somesalt"), 1, 64, 1) {
+		t.Fatalf("unexpected DeriveSecureKey result (%v)", err)
+	}
+	expected, err := crypto.DeriveKeyWithParams(password, sampleKDFParams)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if key, err := policy.DeriveKeyWithParams(password, sampleKDFParams); err != nil || key != expected {
+		t.Fatalf("unexpected DeriveKeyWithParams result (%v)", err)
+	}
+	// The zero policy accepts anything
+	if err := (crypto.PasswordPolicy{}).Check(""); err != nil {
+		t.Fatalf("expected the zero policy to accept an empty password, instead got %v", err)
+	}
+}
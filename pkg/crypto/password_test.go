@@ -0,0 +1,116 @@
+package crypto_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func ExampleVerifyPassword() {
+	// Stored with `crypto.HashPassword("somepassword")`
+	encoded := "$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$6ALX8d6C2230oF0MctCeedWZ7Td2/lOL+d32zF6JCSU"
+	ok, err := crypto.VerifyPassword("somepassword", encoded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("ok = %v", ok)
+	// Output: ok = true
+}
+
+func TestHashPassword(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Default parameters
+	encoded, err := crypto.HashPassword("hello gopher")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=") || strings.Count(encoded, "$") != 5 {
+		t.Fatalf("unexpected encoded hash %q", encoded)
+	}
+	if ok, err := crypto.VerifyPassword("hello gopher", encoded); !ok || err != nil {
+		t.Fatalf("failed to verify password (%v)", err)
+	}
+	if ok, err := crypto.VerifyPassword("hello gopher!", encoded); ok || err != nil {
+		t.Fatalf("verified the wrong password (%v)", err)
+	}
+	if rehash, err := crypto.NeedsRehash(encoded, crypto.DefaultPasswordParams()); rehash || err != nil {
+		t.Fatalf("expected no rehash with the default parameters, instead got %v (%v)", rehash, err)
+	}
+	// The salt is random
+	other, err := crypto.HashPassword("hello gopher")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if other == encoded {
+		t.Fatalf("hashing the same password twice returned %q both times", encoded)
+	}
+	// Custom parameters (salt is kept)
+	params := sampleKDFParams.Clone()
+	if encoded, err = crypto.HashPasswordWithParams("somepassword", params); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if encoded != samplePHC {
+		t.Fatalf("expected %q, instead got %q", samplePHC, encoded)
+	}
+	params.Time = 0
+	if _, err = crypto.HashPasswordWithParams("somepassword", params); !errors.Is(err, crypto.ErrKDFParams) {
+		t.Fatalf("expected ErrKDFParams, instead got %v", err)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	// Test vectors from the Argon2 reference implementation
+	// (https://github.com/P-H-C/phc-winner-argon2, src/test.c)
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=256,t=2,p=2$c29tZXNhbHQ$bQk8UB/VmZZF4Oo79iDXuL5/0ttZwg2f/5U52iv1cDc",
+	} {
+		if ok, err := crypto.VerifyPassword("password", encoded); !ok || err != nil {
+			t.Fatalf("failed to verify %q (%v)", encoded, err)
+		}
+	}
+	// A 16 byte hash
+	params := sampleKDFParams.Clone()
+	params.KeyLength = 16
+	encoded, err := crypto.HashPasswordWithParams("password", params)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if ok, err := crypto.VerifyPassword("password", encoded); !ok || err != nil {
+		t.Fatalf("failed to verify %q (%v)", encoded, err)
+	}
+	// Invalid encodings
+	for _, invalid := range []string{
+		"",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$!!!",
+		"$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$",
+		// Too expensive to derive
+		"$argon2id$v=19$m=4294967295,t=3,p=1$c29tZXNhbHQ$6ALX8d6C2230oF0MctCeedWZ7Td2/lOL+d32zF6JCSU",
+		"$argon2id$v=19$m=4096,t=4294967295,p=1$c29tZXNhbHQ$6ALX8d6C2230oF0MctCeedWZ7Td2/lOL+d32zF6JCSU",
+	} {
+		if _, err := crypto.VerifyPassword("somepassword", invalid); err == nil {
+			t.Fatalf("expected an error verifying %q", invalid)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	current := sampleKDFParams.Clone()
+	current.Salt = nil
+	if rehash, err := crypto.NeedsRehash(samplePHC, current); rehash || err != nil {
+		t.Fatalf("expected no rehash with the same parameters, instead got %v (%v)", rehash, err)
+	}
+	current.Memory *= 2
+	if rehash, err := crypto.NeedsRehash(samplePHC, current); !rehash || err != nil {
+		t.Fatalf("expected a rehash with more memory, instead got %v (%v)", rehash, err)
+	}
+	if _, err := crypto.NeedsRehash("$invalid", current); err == nil {
+		t.Fatalf("expected an error with an invalid hash")
+	}
+}
@@ -1,15 +1,148 @@
 package crypto
 
 import (
+	"encoding/hex"
+	"time"
+
 	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
 )
 
-// Returns a true-random hex string of a given length
+const (
+	// The RFC 4648 base32 alphabet, for `RandomString`
+	AlphabetBase32 = helpers.AlphabetBase32
+	// The base58 alphabet (no 0, O, I and l), for `RandomString`
+	AlphabetBase58 = helpers.AlphabetBase58
+	// Digits, upper case and lower case letters, for `RandomString`
+	AlphabetAlphanumeric = helpers.AlphabetAlphanumeric
+)
+
+var (
+	// Error returned when a random value is requested with a
+	// negative length
+	ErrRandomLength = helpers.ErrRandomLength
+	// Error returned by `ReadRandomIntn` if n <= 0
+	ErrRandomRange = helpers.ErrRandomRange
+	// Error returned by `ReadRandomString` with an empty alphabet
+	ErrRandomAlphabet = helpers.ErrRandomAlphabet
+)
+
+// A UUID as defined by RFC 9562
+type UUID [helpers.UUIDSize]byte
+
+// Returns a true-random hex string of a given length.
+//
+// Panics if the length is negative or the system's random source
+// fails; use `ReadRandomHex` to handle these errors.
 func RandomHex(length int) string {
 	return helpers.RandomHex(length)
 }
 
-// Returns a true-random uint64 number
+// Returns a true-random uint64 number.
+//
+// Panics if the system's random source fails; use
+// `ReadRandomUint64` to handle this error.
 func RandomUint64() uint64 {
 	return helpers.RandomUint64()
 }
+
+// Returns length true-random bytes.
+//
+// Panics if the length is negative or the system's random source
+// fails; use `ReadRandomBytes` to handle these errors.
+func RandomBytes(length int) []byte {
+	return helpers.RandomBytes(length)
+}
+
+// Returns a true-random number in [0, n).
+//
+// Unlike `RandomUint64() % n`, every number is equally likely.
+// Panics if n <= 0 or the system's random source fails; use
+// `ReadRandomIntn` to handle these errors.
+func RandomIntn(n int) int {
+	return helpers.RandomIntn(n)
+}
+
+// Returns a true-random string of length characters picked from
+// alphabet, such as `AlphabetBase58`; every character is equally
+// likely.
+//
+// Panics if the length is negative, the alphabet is empty or the
+// system's random source fails; use `ReadRandomString` to handle
+// these errors.
+func RandomString(length int, alphabet string) string {
+	return helpers.RandomString(length, alphabet)
+}
+
+// Returns a new random (version 4) UUID.
+//
+// Panics if the system's random source fails; use `ReadUUIDv4` to
+// handle this error.
+func NewUUIDv4() UUID {
+	return helpers.RandomUUIDv4()
+}
+
+// Returns a new time-ordered (version 7) UUID, starting with the
+// current unix time in milliseconds followed by random bits.
+//
+// Panics if the system's random source fails; use `ReadUUIDv7` to
+// handle this error.
+func NewUUIDv7() UUID {
+	return helpers.RandomUUIDv7(time.Now())
+}
+
+// Like `RandomHex`, but returns an error instead of panicking
+func ReadRandomHex(length int) (string, error) {
+	return helpers.ReadRandomHex(length)
+}
+
+// Like `RandomUint64`, but returns an error instead of panicking
+func ReadRandomUint64() (uint64, error) {
+	return helpers.ReadRandomUint64()
+}
+
+// Like `RandomBytes`, but returns an error instead of panicking
+func ReadRandomBytes(length int) ([]byte, error) {
+	return helpers.ReadRandomBytes(length)
+}
+
+// Like `RandomIntn`, but returns an error instead of panicking
+func ReadRandomIntn(n int) (int, error) {
+	return helpers.ReadRandomIntn(n)
+}
+
+// Like `RandomString`, but returns an error instead of panicking
+func ReadRandomString(length int, alphabet string) (string, error) {
+	return helpers.ReadRandomString(length, alphabet)
+}
+
+// Like `NewUUIDv4`, but returns an error instead of panicking
+func ReadUUIDv4() (UUID, error) {
+	return helpers.ReadRandomUUIDv4()
+}
+
+// Like `NewUUIDv7`, but returns an error instead of panicking
+func ReadUUIDv7() (UUID, error) {
+	return helpers.ReadRandomUUIDv7(time.Now())
+}
+
+// Returns the version of the UUID (4 or 7 for the UUIDs generated
+// by this package)
+func (uuid UUID) Version() int {
+	return int(uuid[6] >> 4)
+}
+
+// Returns the UUID in its canonical form, such as
+// "0190163d-8694-739b-aea5-966c26f8ad91"
+func (uuid UUID) String() string {
+	var encoded [36]byte
+	hex.Encode(encoded[0:8], uuid[0:4])
+	encoded[8] = '-'
+	hex.Encode(encoded[9:13], uuid[4:6])
+	encoded[13] = '-'
+	hex.Encode(encoded[14:18], uuid[6:8])
+	encoded[18] = '-'
+	hex.Encode(encoded[19:23], uuid[8:10])
+	encoded[23] = '-'
+	hex.Encode(encoded[24:], uuid[10:])
+	return string(encoded[:])
+}
@@ -0,0 +1,52 @@
+package crypto_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestUUID(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	canonical := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[47][0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for version, uuid := range map[int]crypto.UUID{4: crypto.NewUUIDv4(), 7: crypto.NewUUIDv7()} {
+		if uuid.Version() != version || !canonical.MatchString(uuid.String()) {
+			t.Fatalf("unexpected UUIDv%d %s (version %d)", version, uuid, uuid.Version())
+		}
+	}
+	// The example from RFC 9562 (Appendix A.6)
+	uuid := crypto.UUID{0x01, 0x7f, 0x22, 0xe2, 0x79, 0xb0, 0x7c, 0xc3, 0x98, 0xc4, 0xdc, 0x0c, 0x0c, 0x07, 0x39, 0x8f}
+	if expected := "017f22e2-79b0-7cc3-98c4-dc0c0c07398f"; uuid.String() != expected {
+		t.Fatalf("expected %s, instead got %s", expected, uuid)
+	}
+}
+
+func TestReadRandom(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	if _, err := crypto.ReadRandomHex(-1); err != crypto.ErrRandomLength {
+		t.Fatalf("expected ErrRandomLength, instead got %v", err)
+	}
+	if _, err := crypto.ReadRandomIntn(0); err != crypto.ErrRandomRange {
+		t.Fatalf("expected ErrRandomRange, instead got %v", err)
+	}
+	if _, err := crypto.ReadRandomString(1, ""); err != crypto.ErrRandomAlphabet {
+		t.Fatalf("expected ErrRandomAlphabet, instead got %v", err)
+	}
+	if uuid, err := crypto.ReadUUIDv7(); err != nil || uuid.Version() != 7 {
+		t.Fatalf("unexpected UUID %s (%v)", uuid, err)
+	}
+}
+
+func BenchmarkRandom(b *testing.B) {
+	b.Run("Intn", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = crypto.RandomIntn(1000)
+		}
+	})
+	b.Run("String", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = crypto.RandomString(22, crypto.AlphabetBase58)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// The permissions used when writing a salt file
+	SaltFilePermissions os.FileMode = 0o600
+)
+
+var (
+	// Error returned when a salt is required but `nil` or empty
+	ErrMissingSalt = errors.New("salt is missing")
+	// Error returned when a salt is shorter than `MinKDFSaltSize`
+	ErrShortSalt = fmt.Errorf("salt is shorter than %d bytes", MinKDFSaltSize)
+)
+
+// Generates a new random salt of `KDFSaltSize` bytes
+func NewSalt() (salt []byte, err error) {
+	return SystemEntropy().NewSalt()
+}
+
+// Securely derives a key from a string and some salt
+// using Argon2id, like `DeriveSecureKey`.
+//
+// Unlike `DeriveSecureKey`, it never falls back to `DefaultSalt`:
+// it returns `ErrMissingSalt` if salt is empty and `ErrShortSalt`
+// if it is shorter than `MinKDFSaltSize`. Zero time, memory, and
+// threads are still replaced by the defaults.
+func DeriveSecureKeyStrict(password string, salt []byte, time uint32, memory uint32, threads uint8) (key AESKey, err error) {
+	if err = checkSalt(salt); err != nil {
+		return
+	}
+	return DeriveSecureKey(password, salt, time, memory, threads), nil
+}
+
+// Returns the salt stored at path, creating the file with a new
+// random salt if it doesn't exist.
+//
+// This gives each installation its own salt, for use with
+// `DeriveSecureKey` instead of `DefaultSalt`. The salt isn't secret,
+// but it must be kept: losing it means every key derived with it is
+// lost too. Concurrent callers creating the same file all get the
+// same salt.
+func LoadOrCreateSalt(path string) (salt []byte, err error) {
+	salt, err = os.ReadFile(path)
+	if err == nil {
+		if err = checkSalt(salt); err != nil {
+			salt = nil
+			err = fmt.Errorf("salt file %s: %w", path, err)
+		}
+		return
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if salt, err = NewSalt(); err != nil {
+		return
+	}
+	created, err := writeFileExclusive(path, salt, SaltFilePermissions)
+	if err != nil || created {
+		return
+	}
+	// Someone else created the file first
+	return LoadOrCreateSalt(path)
+}
+
+// Returns an error if salt can't be used for key derivation
+func checkSalt(salt []byte) error {
+	if len(salt) == 0 {
+		return ErrMissingSalt
+	}
+	if len(salt) < MinKDFSaltSize {
+		return ErrShortSalt
+	}
+	return nil
+}
+
+// Writes data to a temporary file next to path and links it into
+// place only if path doesn't exist yet, so that readers never
+// observe a partial file and an existing file is never replaced.
+// Returns false if path already exists.
+func writeFileExclusive(path string, data []byte, perm os.FileMode) (created bool, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Link(tmp.Name(), path); errors.Is(err, fs.ErrExist) {
+		return false, nil
+	} else if err != nil {
+		return
+	}
+	// Persist the link (best effort)
+	if d, dirErr := os.Open(filepath.Dir(path)); dirErr == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return true, nil
+}
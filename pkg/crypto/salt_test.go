@@ -0,0 +1,88 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+func TestNewSalt(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	salt, err := crypto.NewSalt()
+	if err != nil || len(salt) != crypto.KDFSaltSize {
+		t.Fatalf("expected a %d byte salt, instead got %x (%v)", crypto.KDFSaltSize, salt, err)
+	}
+	other, err := crypto.NewSalt()
+	if err != nil || bytes.Equal(salt, other) {
+		t.Fatalf("expected two different salts, instead got %x and %x (%v)", salt, other, err)
+	}
+}
+
+func TestDeriveSecureKeyStrict(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	password := "hello gopher"
+	if _, err := crypto.DeriveSecureKeyStrict(password, nil, 1, 64, 1); !errors.Is(err, crypto.ErrMissingSalt) {
+		t.Fatalf("expected ErrMissingSalt, instead got %v", err)
+	}
+	if _, err := crypto.DeriveSecureKeyStrict(password, []byte{}, 1, 64, 1); !errors.Is(err, crypto.ErrMissingSalt) {
+		t.Fatalf("expected ErrMissingSalt, instead got %v", err)
+	}
+	if _, err := crypto.DeriveSecureKeyStrict(password, []byte("short"), 1, 64, 1); !errors.Is(err, crypto.ErrShortSalt) {
+		t.Fatalf("expected ErrShortSalt, instead got %v", err)
+	}
+	key, err := crypto.DeriveSecureKeyStrict(password, []byte("somesalt"), 1, 64, 1)
+	if err != nil || key != crypto.DeriveSecureKey(password, []byte("somesalt"), 1, 64, 1) {
+		t.Fatalf("expected the same key as DeriveSecureKey (%v)", err)
+	}
+}
+
+func TestLoadOrCreateSalt(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	dir := t.TempDir()
+	path := filepath.Join(dir, "salt")
+	// Created on first use, then reused
+	salt, err := crypto.LoadOrCreateSalt(path)
+	if err != nil || len(salt) != crypto.KDFSaltSize {
+		t.Fatalf("failed to create salt: %x (%v)", salt, err)
+	}
+	if loaded, err := crypto.LoadOrCreateSalt(path); err != nil || !bytes.Equal(loaded, salt) {
+		t.Fatalf("expected %x, instead got %x (%v)", salt, loaded, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != crypto.SaltFilePermissions {
+		t.Fatalf("unexpected salt file mode (%v)", err)
+	}
+	// Concurrent callers agree on the salt
+	concurrentPath := filepath.Join(dir, "concurrent")
+	salts := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range salts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			salts[i], _ = crypto.LoadOrCreateSalt(concurrentPath)
+		}(i)
+	}
+	wg.Wait()
+	for _, other := range salts {
+		if len(other) != crypto.KDFSaltSize || !bytes.Equal(other, salts[0]) {
+			t.Fatalf("expected all callers to get %x, instead got %x", salts[0], other)
+		}
+	}
+	// No temporary files are left behind
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 files, instead got %v (%v)", entries, err)
+	}
+	// Short salt files are rejected
+	shortPath := filepath.Join(dir, "short")
+	if err := os.WriteFile(shortPath, []byte("short"), 0o600); err != nil {
+		t.Fatalf("failed to write %q: %v", shortPath, err)
+	}
+	if _, err := crypto.LoadOrCreateSalt(shortPath); !errors.Is(err, crypto.ErrShortSalt) {
+		t.Fatalf("expected ErrShortSalt, instead got %v", err)
+	}
+}
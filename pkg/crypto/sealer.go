@@ -0,0 +1,39 @@
+package crypto
+
+import (
+	"crypto/cipher"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+)
+
+// Encrypts and decrypts messages with a fixed key, like `Encrypt`
+// and `Decrypt` but without setting up the cipher for every message.
+//
+// A Sealer is safe for concurrent use.
+type Sealer struct {
+	// The AEAD cipher for the key
+	aead cipher.AEAD
+	// The source of the nonces
+	entropy *Entropy
+}
+
+// Creates a `Sealer` for a key
+func NewSealer(key AESKey) *Sealer {
+	return NewSealerWithEntropy(key, SystemEntropy())
+}
+
+// Creates a `Sealer` for a key reading nonces from entropy, which
+// must be `SystemEntropy` outside of tests (see `NewTestingEntropy`)
+func NewSealerWithEntropy(key AESKey, entropy *Entropy) *Sealer {
+	return &Sealer{aead: helpers.NewAEAD(key), entropy: entropy}
+}
+
+// Encrypts a message (see `Encrypt`)
+func (sealer *Sealer) Encrypt(plaintext []byte) (ciphertext []byte, err error) {
+	return helpers.Seal(sealer.aead, sealer.entropy, nil, plaintext)
+}
+
+// Decrypts a message from `Encrypt` (see `Decrypt`)
+func (sealer *Sealer) Decrypt(ciphertext []byte) (plaintext []byte, err error) {
+	return helpers.Open(sealer.aead, nil, ciphertext)
+}
@@ -0,0 +1,167 @@
+package message
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// The largest message written by Conn, so that its frames fit
+	// in the read buffer kept by the remote Connection
+	maxConnChunkSize = maxRetainedReadBuffer - FrameOverhead
+)
+
+// A net.Conn on top of a Connection, so that any protocol working
+// over a stream (such as HTTP) can run over an encrypted Connection.
+//
+// Writes are sent as one or more messages, and reads return the
+// messages received as a stream of bytes: message boundaries are
+// not preserved. Both peers must use a Conn (or read the messages
+// as chunks of the stream).
+//
+// A Conn is safe for concurrent use, and each Write is sent as a
+// whole even with concurrent writes.
+type Conn struct {
+	// The underlying Connection
+	connection *Connection
+	// A lock for the reader
+	readLock *sync.Mutex
+	// The unread part of the last message received (a slice of
+	// readBuffer)
+	unread []byte
+	// The buffer for a message larger than the reads, taken from the
+	// pool of the Connections until it is read (nil otherwise)
+	readBuffer *[]byte
+	// A lock for the writer
+	writeLock *sync.Mutex
+}
+
+// Returns a net.Conn reading and writing through connection
+func NewConn(connection *Connection) *Conn {
+	return &Conn{
+		connection: connection,
+		readLock:   &sync.Mutex{},
+		writeLock:  &sync.Mutex{},
+	}
+}
+
+// Returns the underlying Connection
+func (conn *Conn) Connection() *Connection {
+	return conn.connection
+}
+
+// Reads up to len(p) bytes of the stream, from the unread part of
+// the last message or from the next message (implementing net.Conn).
+//
+// Returns io.EOF once the remote peer closes the connection (or its
+// side of it, see `CloseWrite`).
+func (conn *Conn) Read(p []byte) (n int, err error) {
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+	if len(p) == 0 {
+		return
+	}
+	for len(conn.unread) == 0 {
+		// Read straight into p if the message fits
+		n, err = conn.connection.ReadMessageInto(p)
+		if err == io.ErrShortBuffer {
+			buffer := readBufferPool.Get().(*[]byte)
+			if *buffer, err = conn.connection.AppendMessage((*buffer)[:0]); err != nil {
+				putReadBuffer(buffer)
+				return
+			}
+			conn.readBuffer, conn.unread = buffer, *buffer
+		}
+		if err != nil || n > 0 {
+			return
+		}
+		// Skip empty messages
+	}
+	n = copy(p, conn.unread)
+	conn.unread = conn.unread[n:]
+	if len(conn.unread) == 0 {
+		putReadBuffer(conn.readBuffer)
+		conn.readBuffer = nil
+	}
+	return
+}
+
+// Writes p as one or more messages (implementing net.Conn), sent
+// with a single write on the underlying connection in batching mode
+// (see `WithBatching`).
+//
+// On errors, n only counts the bytes of the messages which were
+// sent on the underlying connection.
+func (conn *Conn) Write(p []byte) (n int, err error) {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	chunkSize := min(conn.connection.maxMessageSize, maxConnChunkSize)
+	// The bytes of p sent so far (the rest are queued)
+	sent := 0
+	for n < len(p) {
+		chunk := p[n:min(n+chunkSize, len(p))]
+		flushed, err := conn.connection.writeMessage(chunk)
+		if err != nil {
+			return sent, err
+		}
+		n += len(chunk)
+		if flushed {
+			sent = n
+		}
+	}
+	if err = conn.connection.Flush(); err != nil {
+		n = sent
+	}
+	return
+}
+
+// Closes the Connection (see `Connection.Close`)
+func (conn *Conn) Close() error {
+	return conn.connection.Close()
+}
+
+// Shuts down the writing side of the underlying connection, after
+// sending the queued messages, if it supports it (like
+// *net.TCPConn and *net.UnixConn). The remote Conn then reads io.EOF.
+//
+// Returns an error matching errors.ErrUnsupported otherwise.
+func (conn *Conn) CloseWrite() (err error) {
+	closer, ok := conn.connection.NetConn().(interface{ CloseWrite() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err = conn.connection.Flush(); err != nil {
+		return
+	}
+	return closer.CloseWrite()
+}
+
+// Returns the local address of the underlying connection
+func (conn *Conn) LocalAddr() net.Addr {
+	return conn.connection.NetConn().LocalAddr()
+}
+
+// Returns the remote address of the underlying connection
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.connection.NetConn().RemoteAddr()
+}
+
+// Sets the read and write deadlines of the underlying connection
+// (see `Connection.SetDeadline`)
+func (conn *Conn) SetDeadline(t time.Time) error {
+	return conn.connection.SetDeadline(t)
+}
+
+// Sets the read deadline of the underlying connection
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	return conn.connection.SetReadDeadline(t)
+}
+
+// Sets the write deadline of the underlying connection (see
+// `SetDeadline`)
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	return conn.connection.SetWriteDeadline(t)
+}
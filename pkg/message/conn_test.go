@@ -0,0 +1,213 @@
+package message_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/message"
+)
+
+// Conn implements net.Conn
+var _ net.Conn = (*message.Conn)(nil)
+
+// Returns two connected Conns, closed at the end of the test
+func testConns(t *testing.T, opts ...message.Option) (client *message.Conn, server *message.Conn) {
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	client = message.NewConn(message.Client(clientConn, key, opts...))
+	server = message.NewConn(message.Server(serverConn, key, opts...))
+	return
+}
+
+func TestConnStream(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	for name, opts := range map[string][]message.Option{
+		"default":  nil,
+		"batching": {message.WithBatching()},
+		"uint16":   {message.WithFramer(message.FramerUint16)},
+		"small":    {message.WithMaxFrameSize(message.FrameOverhead + 100)},
+	} {
+		client, server := testConns(t, opts...)
+		// The server echoes the stream back
+		go func() {
+			_, _ = io.Copy(server, server)
+			_ = server.CloseWrite()
+		}()
+		data := make([]byte, 1<<20)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("%s: failed to generate data: %v", name, err)
+		}
+		go func() {
+			// Writes of all sizes, including empty ones
+			for written := 0; written < len(data); {
+				size := min(len(data)-written, 2*written+1)
+				_, _ = client.Write(data[written : written+size])
+				_, _ = client.Write(nil)
+				written += size
+			}
+			_ = client.CloseWrite()
+		}()
+		// Reads of 1 byte, then as large as possible
+		first := make([]byte, 1)
+		if _, err := io.ReadFull(iotest.OneByteReader(client), first); err != nil {
+			t.Fatalf("%s: failed to read: %v", name, err)
+		}
+		rest, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatalf("%s: failed to read: %v", name, err)
+		}
+		if echoed := append(first, rest...); !bytes.Equal(echoed, data) {
+			t.Fatalf("%s: expected the same %d bytes back, instead got %d bytes", name, len(data), len(echoed))
+		}
+	}
+}
+
+func TestConnPassthrough(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, server := testConns(t)
+	clientConn := client.Connection().NetConn()
+	if client.LocalAddr() != clientConn.LocalAddr() || client.RemoteAddr() != clientConn.RemoteAddr() {
+		t.Fatalf("expected the addresses of the underlying connection")
+	}
+	go func() { _ = server.Connection().Handshake() }()
+	if err := client.Connection().Handshake(); err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if err := client.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set the deadline: %v", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read to time out, instead got %v", err)
+	}
+	// Without CloseWrite
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	conn := message.NewConn(message.Client(&struct{ net.Conn }{pipe}, helpers.DeriveKey("")))
+	if err := conn.CloseWrite(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected errors.ErrUnsupported, instead got %v", err)
+	}
+}
+
+func TestConnCloseDuringWrite(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, server := testConns(t)
+	go func() { _ = server.Connection().Handshake() }()
+	// The server doesn't read more than the socket buffers hold
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := client.Write(make([]byte, 12<<20))
+		writeErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Close to return during a blocked write")
+	}
+	select {
+	case err := <-writeErr:
+		if err == nil {
+			t.Fatalf("expected the write to fail once closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Close to interrupt the blocked write")
+	}
+}
+
+func TestConnWriteError(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	for name, testCase := range map[string]struct {
+		opts   []message.Option
+		writes int64
+		n      int
+	}{
+		// Each chunk is sent with its own write
+		"default": {writes: 2, n: 80000},
+		// The first two chunks are sent once they fill the batch, the
+		// third one by the final flush
+		"batching":        {opts: []message.Option{message.WithBatching()}, writes: 1, n: 80000},
+		"batching failed": {opts: []message.Option{message.WithBatching()}, writes: 0, n: 0},
+	} {
+		opts := append(testCase.opts, message.WithMaxFrameSize(message.FrameOverhead+40000))
+		clientConn, serverConn := testConnPair(t)
+		failing := &failingConn{Conn: clientConn}
+		failing.writes.Store(-1)
+		client := message.Client(failing, key, opts...)
+		go func() { _ = message.Server(serverConn, key, opts...).Handshake() }()
+		if err := client.Handshake(); err != nil {
+			t.Fatalf("%s: failed to handshake: %v", name, err)
+		}
+		// Three chunks of 40000 bytes
+		failing.writes.Store(testCase.writes)
+		n, err := message.NewConn(client).Write(make([]byte, 120000))
+		if err == nil || n != testCase.n {
+			t.Fatalf("%s: expected %d bytes written and an error, instead got %d (%v)", name, testCase.n, n, err)
+		}
+	}
+}
+
+// A net.Conn whose writes fail once the remaining writes reach 0
+// (unlimited if negative)
+type failingConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (conn *failingConn) Write(p []byte) (n int, err error) {
+	if conn.writes.Load() == 0 {
+		return 0, errors.New("write failed")
+	}
+	conn.writes.Add(-1)
+	return conn.Conn.Write(p)
+}
+
+func TestConnHTTP(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	listener, err := message.Listen("tcp", "127.0.0.1:0", key)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello " + r.URL.Path[1:]))
+		}),
+	}
+	go func() { _ = server.Serve(message.NewConnListener(listener)) }()
+	t.Cleanup(func() { _ = server.Close() })
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) {
+			conn, err := net.Dial(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return message.NewConn(message.Client(conn, key)), nil
+		},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+	for _, name := range []string{"gopher", "world"} {
+		response, err := client.Get("http://" + listener.Addr().String() + "/" + name)
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil || string(body) != "hello "+name {
+			t.Fatalf("expected %q, instead got %q (%v)", "hello "+name, body, err)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package message
 
 import (
-	"encoding/binary"
+	"bufio"
+	"crypto/cipher"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
 	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
@@ -17,109 +23,380 @@ var (
 	// Error returned when the remote message has the wrong
 	// value for the challenge
 	ErrInvalidRemoteMessageChallenge = errors.New("remote message provided the wrong challenge")
+	// Error returned by the reads (or writes) of a Connection after
+	// a read (or write) failed part way through a frame, such as when
+	// interrupted by a deadline or a context: the stream can't be
+	// resumed, and the Connection should be closed
+	ErrConnectionBroken = errors.New("connection broken by a failed read or write")
+	// Error returned by `NewConnection` for an unknown `Role`
+	ErrInvalidRole = errors.New("invalid role")
+	// Error matched (with errors.Is) by `FrameTooLargeError`
+	ErrFrameTooLarge = helpers.ErrFrameTooLarge
+	// Error returned when writing a message too long for the length
+	// prefix of the `Framer`
+	ErrFrameLength = helpers.ErrFrameLength
 )
 
+// The error returned when the remote peer sends a frame larger than
+// the maximum frame size, or when writing one (see `WithMaxFrameSize`)
+type FrameTooLargeError = helpers.FrameTooLargeError
+
 // Connection can manage a connection and other factors such as
 // cryptography and security, providing a simple interface to
 // read and write data
 type Connection struct {
 	// The underlying connection
 	conn net.Conn
-	// The encryption key
-	key crypto.AESKey
+	// Reads from conn through a buffer, counting the bytes consumed
+	reader *countingReader
+	// Reads the frames from reader
+	decoder *helpers.Decoder
+	// Writes the frames into conn
+	encoder *helpers.Encoder
+	// Whether WriteMessage queues messages until Flush
+	batching bool
+	// The maximum size of a frame, sent or received
+	maxFrameSize uint64
+	// The largest message fitting in a frame
+	maxMessageSize int
+	// The role of the local peer in the handshake
+	role Role
+	// The ciphers for the session keys of each direction, derived
+	// from the pre-shared key and both challenges by the handshake
+	sendCipher    cipher.AEAD
+	receiveCipher cipher.AEAD
 	// A lock for the sender
 	writeLock *sync.Mutex
+	// The error which broke the sender, if any
+	writeErr error
 	// The remote challenge value
 	challengeRemote uint64
 	// A counter tracking the number of messages received
 	counterRemote uint64
 	// A lock for the receiver
 	readLock *sync.Mutex
+	// The error which broke the receiver, if any
+	readErr error
+	// The buffer the last frame was read and decrypted into, taken
+	// from readBufferPool until the message is consumed (nil otherwise)
+	readBuffer *[]byte
+	// A received message not returned yet (a slice of readBuffer),
+	// see ReadMessageInto
+	pending []byte
 	// The local challenge value
 	challengeLocal uint64
 	// A counter tracking the number of messages sent
 	counterLocal uint64
+	// The source of the challenge and nonces
+	entropy *crypto.Entropy
+	// The pre-shared key, cleared by the handshake
+	preSharedKey crypto.AESKey
+	// How long the handshake can take (0 for no limit)
+	handshakeTimeout time.Duration
+	// A lock for the handshake
+	handshakeLock *sync.Mutex
+	// Whether the handshake succeeded
+	handshakeComplete atomic.Bool
+	// The error of the handshake, if it failed
+	handshakeErr error
+	// A lock for the deadlines (see applyDeadlines)
+	deadlineLock *sync.Mutex
+	// The deadlines set with SetDeadline, SetReadDeadline and
+	// SetWriteDeadline
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// The deadline of the handshake in progress, if any
+	handshakeDeadline time.Time
+	// How many done contexts are interrupting the reads and the
+	// writes (see withContext)
+	readInterrupts  int
+	writeInterrupts int
 }
 
 // Performs a handshake and returns a Connection object or an error otherwise
-func NewConnection(conn net.Conn, key crypto.AESKey) (connection *Connection, err error) {
-	connection = &Connection{
-		conn:      conn,
-		writeLock: &sync.Mutex{},
-		readLock:  &sync.Mutex{},
+//
+// NOTE: without `WithRole`, the handshake is symmetric and does not
+// authenticate the direction of the connection: both peers only
+// prove they have the key. Prefer `Client` and `Server` (or
+// `WithRole`) so that each peer checks the role of the other.
+func NewConnection(conn net.Conn, key crypto.AESKey, opts ...Option) (connection *Connection, err error) {
+	cfg := newConfig(opts)
+	if cfg.role > RoleServer {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRole, cfg.role)
 	}
+	connection = newConnection(conn, key, cfg)
 	// Perform handshake
-	err = connection.handshake()
+	err = connection.Handshake()
 	return
 }
 
-// Closes the underlying connection
+// Returns a Connection for the client side of conn (see `RoleClient`),
+// which performs the handshake on the first call to Handshake, a
+// read, or a write
+func Client(conn net.Conn, key crypto.AESKey, opts ...Option) *Connection {
+	return newConnection(conn, key, newConfig(append(opts, WithRole(RoleClient))))
+}
+
+// Returns a Connection for the server side of conn (see `RoleServer`),
+// which performs the handshake on the first call to Handshake, a
+// read, or a write
+func Server(conn net.Conn, key crypto.AESKey, opts ...Option) *Connection {
+	return newConnection(conn, key, newConfig(append(opts, WithRole(RoleServer))))
+}
+
+// Returns a Connection which didn't perform the handshake yet
+func newConnection(conn net.Conn, key crypto.AESKey, cfg *config) *Connection {
+	// Buffered, so that reading a length prefix doesn't take a read
+	// on conn for each byte
+	reader := &countingReader{reader: bufio.NewReaderSize(conn, readBufferSize)}
+	return &Connection{
+		conn:             conn,
+		reader:           reader,
+		decoder:          helpers.NewFramedDecoder(reader, cfg.framer, cfg.maxFrameSize),
+		encoder:          helpers.NewEncoder(conn, cfg.framer),
+		batching:         cfg.batching,
+		maxFrameSize:     cfg.maxFrameSize,
+		maxMessageSize:   int(max(min(cfg.maxFrameSize, cfg.framer.MaxLength(), math.MaxInt), FrameOverhead+1) - FrameOverhead),
+		role:             cfg.role,
+		writeLock:        &sync.Mutex{},
+		readLock:         &sync.Mutex{},
+		entropy:          cfg.entropy,
+		preSharedKey:     key,
+		handshakeTimeout: cfg.handshakeTimeout,
+		handshakeLock:    &sync.Mutex{},
+		deadlineLock:     &sync.Mutex{},
+	}
+}
+
+// Closes the underlying connection, after trying to send the
+// messages queued in batching mode (see `WithBatching`) for up to 5
+// seconds.
+//
+// Close never waits for a write in progress: the queued messages are
+// dropped, and the blocked write is interrupted (as with any
+// net.Conn).
 func (conn *Connection) Close() (err error) {
-	return conn.conn.Close()
+	var flushErr error
+	if conn.handshakeComplete.Load() && conn.writeLock.TryLock() {
+		if conn.writeErr == nil && conn.encoder.Buffered() > 0 {
+			// The connection is closed right after, so its deadline
+			// doesn't need to be restored
+			_ = conn.conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+			flushErr = conn.encoder.Flush()
+		}
+		conn.writeLock.Unlock()
+	}
+	err = conn.conn.Close()
+	if err == nil {
+		err = flushErr
+	}
+	return
 }
 
-// Writes a message to the remote connection
+// Returns the underlying connection.
+//
+// Reading from it directly would miss the data the Connection has
+// already buffered.
+func (conn *Connection) NetConn() net.Conn {
+	return conn.conn
+}
+
+// Writes a message to the remote connection, with a single write
+// on the underlying connection.
+//
+// In batching mode (see `WithBatching`) the message is queued
+// instead, and sent by `Flush`.
+//
+// A message whose frame would be larger than the maximum frame size
+// (see `WithMaxFrameSize`) is rejected with a `*FrameTooLargeError`
+// before being sent.
 func (conn *Connection) WriteMessage(data []byte) (err error) {
+	_, err = conn.writeMessage(data)
+	return
+}
+
+// Writes a message like WriteMessage, and returns whether the queued
+// messages (including this one) were sent
+func (conn *Connection) writeMessage(data []byte) (sent bool, err error) {
+	if err = conn.ensureHandshake(); err != nil {
+		return
+	}
+	// The remote peer would refuse the frame and break its receiver
+	if size := uint64(len(data)) + FrameOverhead; size > conn.maxFrameSize {
+		err = &FrameTooLargeError{Size: size, MaxSize: conn.maxFrameSize}
+		return
+	}
 	conn.writeLock.Lock()
 	defer conn.writeLock.Unlock()
+	if conn.writeErr != nil {
+		err = fmt.Errorf("%w: %w", ErrConnectionBroken, conn.writeErr)
+		return
+	}
 	// Generate the next sequence number
 	sequenceNumber := conn.nextOutgoingSequenceNumber()
-	// AppendChallenge > Encrypt > PrefixWithLength > Write
-	return helpers.WriteEncryptedMessage(conn.conn, conn.key, sequenceNumber, data)
+	// AppendChallenge > Encrypt > PrefixWithLength > Queue
+	if err = conn.encoder.AppendMessage(conn.sendCipher, conn.entropy, sequenceNumber, data); err != nil {
+		// Nothing was queued, so the sequence number can be reused
+		conn.counterLocal--
+		return
+	}
+	if conn.batching && conn.encoder.Buffered() < maxBatchSize {
+		return
+	}
+	// Write
+	if err = conn.flush(); err != nil {
+		return
+	}
+	return true, nil
+}
+
+// Sends the messages queued in batching mode (see `WithBatching`)
+// with a single write on the underlying connection.
+//
+// If the write fails the queued messages are lost, and the
+// connection should be closed.
+func (conn *Connection) Flush() (err error) {
+	if err = conn.ensureHandshake(); err != nil {
+		return
+	}
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	if conn.writeErr != nil {
+		return fmt.Errorf("%w: %w", ErrConnectionBroken, conn.writeErr)
+	}
+	return conn.flush()
+}
+
+// Writes the queued frames, breaking the sender if it fails (part
+// of a frame may have been written). Must be called with the
+// writeLock held.
+func (conn *Connection) flush() (err error) {
+	if err = conn.encoder.Flush(); err != nil {
+		conn.writeErr = err
+	}
+	return
 }
 
 // Reads a block of data from the connection
 func (conn *Connection) ReadMessage() (data []byte, err error) {
+	if err = conn.ensureHandshake(); err != nil {
+		return
+	}
 	conn.readLock.Lock()
 	defer conn.readLock.Unlock()
-	// Generate the next expected sequence number
-	sequenceNumber := conn.nextExpectedIncomingSequenceNumber()
-	// Read > ExtractLength > Decrypt > VerifyChallenge
-	return helpers.ReadEncryptedMessage(conn.conn, conn.key, sequenceNumber)
+	message, err := conn.receive()
+	if err != nil {
+		return
+	}
+	data = append(make([]byte, 0, len(message)), message...)
+	conn.release()
+	return
 }
 
-// Performs a challenge-response handshake
-func (conn *Connection) handshake() (err error) {
-	// To prevent replay-attacks we perform a handshake where the
-	// two peers exchange a challenge.
-	//
-	// The handshake (from the local prospective) goes as such:
-	// 1. we generate a challenge and send it to the *remote* peer
-	// 2. we receive the *remote* challenge
-	//
-	// For each message sent, we'll prefix the data with
-	// `((challengeLocal * counterLocal) ^ challengeRemote)`
-	// and we will increment `counterLocal`.
-	//
-	// For each message received we'll read the prefix and expect it
-	// to match `(challengeLocal ^ (challengeRemote * counterRemote))`
-	// and increment `counterRemote`.
-	//
-	// If the remote message prefix does not match our expected value
-	// we can close the connection and return an error.
-
-	// Initialize the counters
-	conn.counterLocal = 0
-	conn.counterRemote = 0
-	// Initialize the local challenge
-	conn.challengeLocal = helpers.RandomUint64()
-
-	// Exchange the challenges (send local)
-	localChallengeBytes := binary.BigEndian.AppendUint64(nil, conn.challengeLocal)
-	err = helpers.WriteEncrypted(conn.conn, conn.key, localChallengeBytes)
+// Reads a message from the connection into buf, returning its size.
+//
+// The message is decrypted in place in a buffer from a pool shared
+// by all the Connections, and the buffer goes back to the pool once
+// the message is copied into buf: receiving messages into the same
+// buf doesn't allocate, and idle Connections don't hold a buffer. If the message doesn't fit in buf, returns
+// `io.ErrShortBuffer` and keeps the message for the next call to
+// ReadMessageInto, AppendMessage or ReadMessage.
+func (conn *Connection) ReadMessageInto(buf []byte) (n int, err error) {
+	if err = conn.ensureHandshake(); err != nil {
+		return
+	}
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+	message, err := conn.receive()
 	if err != nil {
 		return
 	}
+	if len(message) > len(buf) {
+		conn.pending = message
+		err = io.ErrShortBuffer
+		return
+	}
+	n = copy(buf, message)
+	conn.release()
+	return
+}
 
-	// Exchange the challenges (receive remote)
-	remoteChallengeBytes, err := helpers.ReadEncrypted(conn.conn, conn.key)
+// Reads a message from the connection and appends it to dst (see
+// `ReadMessageInto`)
+func (conn *Connection) AppendMessage(dst []byte) (data []byte, err error) {
+	if err = conn.ensureHandshake(); err != nil {
+		return dst, err
+	}
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+	message, err := conn.receive()
 	if err != nil {
+		return dst, err
+	}
+	data = append(dst, message...)
+	conn.release()
+	return
+}
+
+// Returns the pending message, or reads and decrypts the next one
+// into a buffer from readBufferPool. Must be called with the
+// readLock held.
+func (conn *Connection) receive() (message []byte, err error) {
+	if conn.pending != nil {
+		return conn.pending, nil
+	}
+	if conn.readErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnectionBroken, conn.readErr)
+	}
+	// Generate the next expected sequence number
+	sequenceNumber := conn.nextExpectedIncomingSequenceNumber()
+	// Read > ExtractLength > Decrypt > VerifyChallenge
+	read := conn.reader.count
+	buffer := readBufferPool.Get().(*[]byte)
+	frame, err := conn.decoder.DecodeAppend((*buffer)[:0])
+	if err != nil {
+		readBufferPool.Put(buffer)
+		if conn.reader.count == read {
+			// Nothing was read (such as after a deadline), so the
+			// next frame can still be read
+			conn.counterRemote--
+		} else {
+			conn.readErr = err
+		}
 		return
 	}
-	conn.challengeRemote = binary.BigEndian.Uint64(remoteChallengeBytes)
+	*buffer = frame
+	conn.readBuffer = buffer
+	message, err = helpers.OpenMessage(conn.receiveCipher, frame, sequenceNumber)
+	if err != nil {
+		conn.readErr = err
+		conn.release()
+	}
 	return
 }
 
+// Drops the message returned by receive, returning small buffers to
+// the pool. Must be called with the readLock held.
+func (conn *Connection) release() {
+	conn.pending = nil
+	putReadBuffer(conn.readBuffer)
+	conn.readBuffer = nil
+}
+
+// The buffers messages are received into, shared by all the
+// Connections (and Conns) so that idle ones don't hold a buffer
+var readBufferPool = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// Returns buffer to readBufferPool, unless it is nil or too large
+// to keep
+func putReadBuffer(buffer *[]byte) {
+	if buffer != nil && cap(*buffer) <= maxRetainedReadBuffer {
+		readBufferPool.Put(buffer)
+	}
+}
+
 // Returns the sequence number to be appended to the next outgoing message
 func (conn *Connection) nextOutgoingSequenceNumber() (sequenceNumber uint64) {
 	// Please see the comment in handshake() for an understanding
@@ -151,3 +428,19 @@ func (conn *Connection) nextExpectedIncomingSequenceNumber() (sequenceNumber uin
 	conn.counterRemote++
 	return
 }
+
+// An io.Reader counting the bytes read
+type countingReader struct {
+	// The underlying reader
+	reader io.Reader
+	// The number of bytes read so far (consumed from the buffer, not
+	// read from the connection)
+	count uint64
+}
+
+// Reads from the underlying reader
+func (reader *countingReader) Read(p []byte) (n int, err error) {
+	n, err = reader.reader.Read(p)
+	reader.count += uint64(n)
+	return
+}
@@ -3,6 +3,8 @@ package message_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
 	"github.com/stefanovazzocell/GoSymCryto/pkg/message"
 )
 
@@ -141,7 +144,7 @@ func testServer(reply []byte, key [helpers.KeySize]byte) (listener net.Listener,
 			log.Printf("[server] accept (err=%v)", err)
 			if errors.Is(err, net.ErrClosed) {
 				log.Printf("[server] closed")
-				continue // We're done here
+				return // We're done here
 			}
 			if err != nil {
 				continue // Whoops
@@ -177,3 +180,607 @@ func testServer(reply []byte, key [helpers.KeySize]byte) (listener net.Listener,
 	// Return listener
 	return
 }
+
+func TestConnectionEntropy(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	// The same seeds give the same transcript
+	transcripts := [2][]byte{}
+	for i := range transcripts {
+		clientEntropy, err := crypto.NewSeededTestingEntropy([]byte("client"))
+		if err != nil {
+			t.Fatalf("failed to create testing entropy: %v", err)
+		}
+		serverEntropy, err := crypto.NewSeededTestingEntropy([]byte("server"))
+		if err != nil {
+			t.Fatalf("failed to create testing entropy: %v", err)
+		}
+		clientConn, serverConn := testConnPair(t)
+		recorder := &recordingConn{Conn: clientConn}
+		go func() {
+			connection, err := message.NewConnection(serverConn, key, message.WithEntropy(serverEntropy))
+			if err != nil {
+				return
+			}
+			if data, err := connection.ReadMessage(); err == nil {
+				_ = connection.WriteMessage(data)
+			}
+		}()
+		connection, err := message.NewConnection(recorder, key, message.WithEntropy(clientEntropy))
+		if err != nil {
+			t.Fatalf("failed to handshake: %v", err)
+		}
+		if err = connection.WriteMessage([]byte("hello gopher")); err != nil {
+			t.Fatalf("failed to write message: %v", err)
+		}
+		if data, err := connection.ReadMessage(); err != nil || string(data) != "hello gopher" {
+			t.Fatalf("unexpected reply %q (%v)", data, err)
+		}
+		transcripts[i] = recorder.written.Bytes()
+	}
+	if len(transcripts[0]) == 0 || !bytes.Equal(transcripts[0], transcripts[1]) {
+		t.Fatalf("expected the same transcripts, instead got %x and %x", transcripts[0], transcripts[1])
+	}
+}
+
+// A net.Conn recording the data written to it
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+	writes  int
+}
+
+func (conn *recordingConn) Write(p []byte) (n int, err error) {
+	conn.writes++
+	conn.written.Write(p)
+	return conn.Conn.Write(p)
+}
+
+// Returns two connected TCP connections, closed at the end of the test
+func testConnPair(t testing.TB) (client net.Conn, server net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	if client, err = net.Dial("tcp", listener.Addr().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if server = <-accepted; server == nil {
+		t.Fatalf("failed to accept the connection")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, conn := range []net.Conn{client, server} {
+		conn := conn
+		_ = conn.SetDeadline(deadline)
+		t.Cleanup(func() { _ = conn.Close() })
+	}
+	return
+}
+
+func TestConnectionMaxFrameSize(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	serverErr := make(chan error, 1)
+	go func() {
+		connection, err := message.NewConnection(serverConn, key, message.WithMaxFrameSize(message.FrameOverhead+10))
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		if data, err := connection.ReadMessage(); err != nil || string(data) != "0123456789" {
+			serverErr <- fmt.Errorf("unexpected message %q (%v)", data, err)
+			return
+		}
+		_, err = connection.ReadMessage()
+		serverErr <- err
+	}()
+	connection, err := message.NewConnection(clientConn, key)
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	// The largest message allowed, then one too large
+	for _, data := range []string{"0123456789", "0123456789!"} {
+		if err = connection.WriteMessage([]byte(data)); err != nil {
+			t.Fatalf("failed to write message: %v", err)
+		}
+	}
+	var tooLarge *message.FrameTooLargeError
+	if err := <-serverErr; !errors.As(err, &tooLarge) || !errors.Is(err, message.ErrFrameTooLarge) {
+		t.Fatalf("expected a FrameTooLargeError, instead got %v", err)
+	}
+	// The sender refuses frames above its own limit, without
+	// breaking the connection
+	client, server := testConnections(t, message.WithMaxFrameSize(message.FrameOverhead+10))
+	if err := client.WriteMessage([]byte("0123456789!")); !errors.As(err, &tooLarge) || !errors.Is(err, message.ErrFrameTooLarge) {
+		t.Fatalf("expected a FrameTooLargeError, instead got %v", err)
+	}
+	if err := client.WriteMessage([]byte("0123456789")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := server.ReadMessage(); err != nil || string(data) != "0123456789" {
+		t.Fatalf("unexpected message %q (%v)", data, err)
+	}
+}
+
+func TestConnectionFramers(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	for name, framer := range map[string]message.Framer{
+		"uvarint": message.FramerUvarint,
+		"uint16":  message.FramerUint16,
+		"uint32":  message.FramerUint32,
+		"uint64":  message.FramerUint64,
+	} {
+		clientConn, serverConn := testConnPair(t)
+		recorder := &recordingConn{Conn: clientConn}
+		go func() {
+			connection, err := message.NewConnection(serverConn, key, message.WithFramer(framer))
+			if err != nil {
+				return
+			}
+			if data, err := connection.ReadMessage(); err == nil {
+				_ = connection.WriteMessage(data)
+			}
+		}()
+		connection, err := message.NewConnection(recorder, key, message.WithFramer(framer))
+		if err != nil {
+			t.Fatalf("%s: failed to handshake: %v", name, err)
+		}
+		if err = connection.WriteMessage([]byte("ping")); err != nil {
+			t.Fatalf("%s: failed to write message: %v", name, err)
+		}
+		if data, err := connection.ReadMessage(); err != nil || string(data) != "ping" {
+			t.Fatalf("%s: unexpected reply %q (%v)", name, data, err)
+		}
+		// The hello (5 bytes), challenge, key confirmation (12 bytes)
+		// and message frames (the 8 byte challenge has the size of a
+		// sequence number), each with its prefix
+		prefix, _ := framer.AppendLength(nil, 0)
+		if expected := 4*len(prefix) + 5 + 2*message.FrameOverhead + (message.FrameOverhead + 4) + len("ping"); recorder.written.Len() != expected {
+			t.Fatalf("%s: expected %d bytes written, instead got %d", name, expected, recorder.written.Len())
+		}
+	}
+	// Messages too long for the prefix
+	clientConn, serverConn := testConnPair(t)
+	go func() { _, _ = message.NewConnection(serverConn, key, message.WithFramer(message.FramerUint16)) }()
+	connection, err := message.NewConnection(clientConn, key, message.WithFramer(message.FramerUint16))
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if err = connection.WriteMessage(make([]byte, 1<<16)); !errors.Is(err, message.ErrFrameLength) {
+		t.Fatalf("expected ErrFrameLength, instead got %v", err)
+	}
+}
+
+func TestConnectionBatching(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	recorder := &recordingConn{Conn: clientConn}
+	received := make(chan []byte, 4)
+	go func() {
+		connection, err := message.NewConnection(serverConn, key)
+		if err != nil {
+			return
+		}
+		for {
+			data, err := connection.ReadMessage()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- data
+		}
+	}()
+	connection, err := message.NewConnection(recorder, key, message.WithBatching())
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	handshakeWrites := recorder.writes
+	if handshakeWrites != 2 {
+		t.Fatalf("expected the handshake to take 2 writes, instead got %d", handshakeWrites)
+	}
+	// Messages are queued until Flush
+	for _, data := range []string{"one", "two", "three"} {
+		if err = connection.WriteMessage([]byte(data)); err != nil {
+			t.Fatalf("failed to write message: %v", err)
+		}
+	}
+	if recorder.writes != handshakeWrites {
+		t.Fatalf("expected no writes before Flush, instead got %d", recorder.writes-handshakeWrites)
+	}
+	if err = connection.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if recorder.writes != handshakeWrites+1 {
+		t.Fatalf("expected 1 write for the batch, instead got %d", recorder.writes-handshakeWrites)
+	}
+	for _, expected := range []string{"one", "two", "three"} {
+		if data := <-received; string(data) != expected {
+			t.Fatalf("expected %q, instead got %q", expected, data)
+		}
+	}
+	// Close sends the queued messages
+	if err = connection.WriteMessage([]byte("last")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if err = connection.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if data := <-received; string(data) != "last" {
+		t.Fatalf("expected %q, instead got %q", "last", data)
+	}
+}
+
+func TestConnectionReadMessageInto(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	go func() {
+		connection, err := message.NewConnection(serverConn, key)
+		if err != nil {
+			return
+		}
+		for _, data := range []string{"hello", "hello gopher", "", "appended", "last"} {
+			if err := connection.WriteMessage([]byte(data)); err != nil {
+				return
+			}
+		}
+	}()
+	connection, err := message.NewConnection(clientConn, key)
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	buf := make([]byte, 8)
+	if n, err := connection.ReadMessageInto(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("expected %q, instead got %q (%v)", "hello", buf[:n], err)
+	}
+	// A message too large for buf is kept for the next call
+	if n, err := connection.ReadMessageInto(buf); err != io.ErrShortBuffer || n != 0 {
+		t.Fatalf("expected io.ErrShortBuffer, instead got %d (%v)", n, err)
+	}
+	buf = make([]byte, 16)
+	if n, err := connection.ReadMessageInto(buf); err != nil || string(buf[:n]) != "hello gopher" {
+		t.Fatalf("expected %q, instead got %q (%v)", "hello gopher", buf[:n], err)
+	}
+	if n, err := connection.ReadMessageInto(nil); err != nil || n != 0 {
+		t.Fatalf("expected an empty message, instead got %d (%v)", n, err)
+	}
+	if data, err := connection.AppendMessage([]byte("prefix ")); err != nil || string(data) != "prefix appended" {
+		t.Fatalf("expected %q, instead got %q (%v)", "prefix appended", data, err)
+	}
+	if data, err := connection.ReadMessage(); err != nil || string(data) != "last" {
+		t.Fatalf("expected %q, instead got %q (%v)", "last", data, err)
+	}
+}
+
+func BenchmarkConnectionReadMessageInto(b *testing.B) {
+	key := helpers.DeriveKey("")
+	for _, framer := range []struct {
+		name   string
+		framer message.Framer
+	}{
+		{"uint64", message.FramerUint64},
+		{"uvarint", message.FramerUvarint},
+	} {
+		b.Run(framer.name, func(b *testing.B) {
+			clientConn, serverConn := testConnPair(b)
+			_ = clientConn.SetDeadline(time.Time{})
+			_ = serverConn.SetDeadline(time.Time{})
+			go func() {
+				connection, err := message.NewConnection(serverConn, key, message.WithBatching(), message.WithFramer(framer.framer))
+				if err != nil {
+					return
+				}
+				data := make([]byte, 64)
+				for i := 0; i < b.N; i++ {
+					if err := connection.WriteMessage(data); err != nil {
+						return
+					}
+				}
+				_ = connection.Flush()
+			}()
+			connection, err := message.NewConnection(clientConn, key, message.WithFramer(framer.framer))
+			if err != nil {
+				b.Fatalf("failed to handshake: %v", err)
+			}
+			buf := make([]byte, 64)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := connection.ReadMessageInto(buf); err != nil {
+					b.Fatalf("failed to read message: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionSessionKey(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	recorder := &recordingConn{Conn: clientConn}
+	go func() {
+		connection, err := message.NewConnection(serverConn, key)
+		if err != nil {
+			return
+		}
+		if data, err := connection.ReadMessage(); err == nil {
+			_ = connection.WriteMessage(data)
+		}
+	}()
+	connection, err := message.NewConnection(recorder, key)
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if err = connection.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := connection.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected reply %q (%v)", data, err)
+	}
+	// The pre-shared key only encrypts the handshake
+	decoder := helpers.NewDecoder(&recorder.written, 0)
+	if _, err := decoder.Decode(); err != nil {
+		t.Fatalf("failed to read the hello: %v", err)
+	}
+	if _, err := helpers.DecodeEncrypted(decoder, key); err != nil {
+		t.Fatalf("failed to decrypt the challenge: %v", err)
+	}
+	if data, err := helpers.DecodeEncryptedMessage(decoder, key, 0); err == nil {
+		t.Fatalf("expected the pre-shared key not to decrypt messages, instead got %q", data)
+	}
+}
+
+func TestConnectionWrongKey(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	clientConn, serverConn := testConnPair(t)
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := message.NewConnection(serverConn, helpers.DeriveKey("server"))
+		serverErr <- err
+	}()
+	if _, err := message.NewConnection(clientConn, helpers.DeriveKey("client")); !errors.Is(err, message.ErrHandshakeAuth) {
+		t.Fatalf("expected the client handshake to fail with ErrHandshakeAuth, instead got %v", err)
+	}
+	if err := <-serverErr; !errors.Is(err, message.ErrHandshakeAuth) {
+		t.Fatalf("expected the server handshake to fail with ErrHandshakeAuth, instead got %v", err)
+	}
+}
+
+func TestConnectionProtocolVersion(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	for name, hello := range map[string][]byte{
+		"next version":   []byte("GSCM\x02"),
+		"other protocol": []byte("HTTP/1.1"),
+		"legacy peer":    make([]byte, message.FrameOverhead),
+	} {
+		clientConn, serverConn := testConnPair(t)
+		if err := helpers.Encode(serverConn, hello); err != nil {
+			t.Fatalf("%s: failed to write hello: %v", name, err)
+		}
+		if _, err := message.NewConnection(clientConn, key); !errors.Is(err, message.ErrProtocolVersion) {
+			t.Fatalf("%s: expected ErrProtocolVersion, instead got %v", name, err)
+		}
+	}
+}
+
+func TestConnectionCloseDuringWrite(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, _ := testConnections(t)
+	// The server doesn't read a message larger than the socket buffers
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- client.WriteMessage(make([]byte, 12<<20)) }()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Close to return during a blocked write")
+	}
+	if err := <-writeErr; err == nil {
+		t.Fatalf("expected the write to fail once closed")
+	}
+}
+
+func TestConnectionHandshakeTimeout(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, _ := testConnPair(t)
+	// The remote peer never answers
+	start := time.Now()
+	_, err := message.NewConnection(clientConn, key, message.WithHandshakeTimeout(50*time.Millisecond))
+	if !errors.Is(err, message.ErrHandshakeTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected ErrHandshakeTimeout, instead got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the handshake to time out after 50ms, instead took %v", elapsed)
+	}
+	// The deadline set by the user is restored after the handshake
+	clientConn, serverConn := testConnPair(t)
+	server := message.Server(serverConn, key)
+	go func() { _ = server.Handshake() }()
+	client := message.Client(clientConn, key, message.WithHandshakeTimeout(time.Minute))
+	if err = client.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set the read deadline: %v", err)
+	}
+	if err = client.Handshake(); err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	start = time.Now()
+	if _, err = client.ReadMessage(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, instead got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out after 50ms, instead took %v", elapsed)
+	}
+	// An earlier deadline set by the user applies to the handshake
+	clientConn, _ = testConnPair(t)
+	client = message.Client(clientConn, key, message.WithHandshakeTimeout(time.Minute))
+	if err = client.SetDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set the deadline: %v", err)
+	}
+	if err = client.Handshake(); !errors.Is(err, message.ErrHandshakeTimeout) {
+		t.Fatalf("expected ErrHandshakeTimeout, instead got %v", err)
+	}
+}
+
+func TestConnectionLazyHandshake(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	recorder := &recordingConn{Conn: clientConn}
+	// Nothing is sent until the first read or write
+	client := message.Client(recorder, key)
+	server := message.Server(serverConn, key)
+	if recorder.writes != 0 {
+		t.Fatalf("expected no writes before the handshake, instead got %d", recorder.writes)
+	}
+	go func() {
+		if data, err := server.ReadMessage(); err == nil {
+			_ = server.WriteMessage(data)
+		}
+	}()
+	if err := client.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := client.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected reply %q (%v)", data, err)
+	}
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("expected the completed handshake to succeed again, instead got %v", err)
+	}
+	// A failed handshake returns the same error every time
+	clientConn, serverConn = testConnPair(t)
+	go func() { _ = message.Server(serverConn, helpers.DeriveKey("other")).Handshake() }()
+	client = message.Client(clientConn, key)
+	err := client.Handshake()
+	if !errors.Is(err, message.ErrHandshakeAuth) {
+		t.Fatalf("expected ErrHandshakeAuth, instead got %v", err)
+	}
+	if _, readErr := client.ReadMessage(); readErr != err {
+		t.Fatalf("expected %v, instead got %v", err, readErr)
+	}
+}
+
+func TestConnectionRoles(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	// A client and a server
+	clientConn, serverConn := testConnPair(t)
+	go func() {
+		connection, err := message.NewConnection(serverConn, key, message.WithRole(message.RoleServer))
+		if err != nil {
+			return
+		}
+		if data, err := connection.ReadMessage(); err == nil {
+			_ = connection.WriteMessage(data)
+		}
+	}()
+	connection, err := message.NewConnection(clientConn, key, message.WithRole(message.RoleClient))
+	if err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if err = connection.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := connection.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected reply %q (%v)", data, err)
+	}
+	// Two clients, or a peer without a role
+	for name, remoteOpts := range map[string][]message.Option{
+		"same role": {message.WithRole(message.RoleClient)},
+		"no role":   nil,
+	} {
+		remoteOpts := remoteOpts
+		clientConn, serverConn := testConnPair(t)
+		go func() {
+			_, _ = message.NewConnection(serverConn, key, remoteOpts...)
+		}()
+		if _, err := message.NewConnection(clientConn, key, message.WithRole(message.RoleClient)); err == nil {
+			t.Fatalf("%s: expected the handshake to fail", name)
+		}
+	}
+	if _, err := message.NewConnection(clientConn, key, message.WithRole(message.RoleServer+1)); !errors.Is(err, message.ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, instead got %v", err)
+	}
+}
+
+func TestConnectionReflection(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	// A peer sending back everything it receives
+	reflect := func() net.Conn {
+		clientConn, serverConn := testConnPair(t)
+		go func() {
+			_, _ = io.Copy(serverConn, serverConn)
+		}()
+		return clientConn
+	}
+	// The handshake fails, with or without roles
+	// With roles the handshake fails
+	for _, role := range []message.Role{message.RoleSymmetric, message.RoleClient, message.RoleServer} {
+		if _, err := message.NewConnection(reflect(), key, message.WithRole(role)); err != message.ErrHandshakeRole {
+			t.Fatalf("%v: expected ErrHandshakeRole, instead got %v", role, err)
+		}
+	}
+	// A man in the middle forwarding the handshake, and then sending
+	// the messages of the client back to it
+	for role, remoteRole := range map[message.Role]message.Role{
+		message.RoleSymmetric: message.RoleSymmetric,
+		message.RoleClient:    message.RoleServer,
+	} {
+		clientConn, proxyClient := testConnPair(t)
+		proxyServer, serverConn := testConnPair(t)
+		const handshakeFrames = 3
+		go func() {
+			decoder := helpers.NewDecoder(proxyServer, 0)
+			for i := 0; i < handshakeFrames; i++ {
+				frame, err := decoder.Decode()
+				if err != nil {
+					return
+				}
+				_ = helpers.Encode(proxyClient, frame)
+			}
+		}()
+		go func() {
+			decoder := helpers.NewDecoder(proxyClient, 0)
+			for i := 0; ; i++ {
+				frame, err := decoder.Decode()
+				if err != nil {
+					return
+				}
+				if i < handshakeFrames {
+					_ = helpers.Encode(proxyServer, frame)
+				} else {
+					_ = helpers.Encode(proxyClient, frame)
+				}
+			}
+		}()
+		go func() {
+			_, _ = message.NewConnection(serverConn, key, message.WithRole(remoteRole))
+		}()
+		connection, err := message.NewConnection(clientConn, key, message.WithRole(role))
+		if err != nil {
+			t.Fatalf("%v: failed to handshake: %v", role, err)
+		}
+		for _, data := range []string{"first", "second"} {
+			if err = connection.WriteMessage([]byte(data)); err != nil {
+				t.Fatalf("%v: failed to write message: %v", role, err)
+			}
+		}
+		if data, err := connection.ReadMessage(); err == nil {
+			t.Fatalf("%v: expected the reflected message to be rejected, instead got %q", role, data)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	// A deadline in the past, interrupting blocked reads and writes
+	pastDeadline = time.Unix(1, 0)
+)
+
+// The directions of a Connection interrupted by a done context (see
+// withContext)
+type interruption uint8
+
+const (
+	interruptRead interruption = 1 << iota
+	interruptWrite
+)
+
+// Reads a message from the connection like `ReadMessage`,
+// interrupting the read when ctx is done: the error then matches
+// ctx.Err().
+//
+// A read interrupted before any of the message arrived can be
+// retried. A read interrupted part way through a message breaks the
+// receiver (see `ErrConnectionBroken`). The read deadline of the
+// underlying connection is restored once the read is interrupted.
+func (conn *Connection) ReadMessageContext(ctx context.Context) (data []byte, err error) {
+	if err = conn.HandshakeContext(ctx); err != nil {
+		return
+	}
+	err = conn.withContext(ctx, interruptRead, func() (err error) {
+		data, err = conn.ReadMessage()
+		return
+	})
+	return
+}
+
+// Writes a message to the remote connection like `WriteMessage`,
+// interrupting the write when ctx is done: the error then matches
+// ctx.Err().
+//
+// An interrupted write may have sent part of the message, so it
+// breaks the sender (see `ErrConnectionBroken`). The write deadline
+// of the underlying connection is restored once the write is
+// interrupted.
+func (conn *Connection) WriteMessageContext(ctx context.Context, data []byte) (err error) {
+	if err = conn.HandshakeContext(ctx); err != nil {
+		return
+	}
+	return conn.withContext(ctx, interruptWrite, func() error {
+		return conn.WriteMessage(data)
+	})
+}
+
+// Sets the read and write deadlines of the underlying connection.
+//
+// A read or write interrupted by its deadline part way through a
+// message breaks the Connection (see `ErrConnectionBroken`). During
+// the handshake, the earliest of this deadline and the handshake
+// timeout applies (see `WithHandshakeTimeout`).
+//
+// Set the deadlines here rather than on the underlying connection,
+// so that the Connection can restore them.
+func (conn *Connection) SetDeadline(t time.Time) error {
+	conn.deadlineLock.Lock()
+	defer conn.deadlineLock.Unlock()
+	conn.readDeadline, conn.writeDeadline = t, t
+	return conn.applyDeadlines()
+}
+
+// Sets the read deadline of the underlying connection (see
+// `SetDeadline`)
+func (conn *Connection) SetReadDeadline(t time.Time) error {
+	conn.deadlineLock.Lock()
+	defer conn.deadlineLock.Unlock()
+	conn.readDeadline = t
+	return conn.applyDeadlines()
+}
+
+// Sets the write deadline of the underlying connection (see
+// `SetDeadline`)
+func (conn *Connection) SetWriteDeadline(t time.Time) error {
+	conn.deadlineLock.Lock()
+	defer conn.deadlineLock.Unlock()
+	conn.writeDeadline = t
+	return conn.applyDeadlines()
+}
+
+// Sets the deadline of the handshake (zero once it is over)
+func (conn *Connection) setHandshakeDeadline(t time.Time) {
+	conn.deadlineLock.Lock()
+	defer conn.deadlineLock.Unlock()
+	conn.handshakeDeadline = t
+	_ = conn.applyDeadlines()
+}
+
+// Adds delta to the interruptions of the directions (see
+// withContext)
+func (conn *Connection) interrupt(directions interruption, delta int) {
+	conn.deadlineLock.Lock()
+	defer conn.deadlineLock.Unlock()
+	if directions&interruptRead != 0 {
+		conn.readInterrupts += delta
+	}
+	if directions&interruptWrite != 0 {
+		conn.writeInterrupts += delta
+	}
+	_ = conn.applyDeadlines()
+}
+
+// Sets the deadlines of the underlying connection to the earliest
+// of the ones set by the user and the one of the handshake, or to a
+// deadline in the past while interrupted; the caller must hold
+// deadlineLock
+func (conn *Connection) applyDeadlines() (err error) {
+	readDeadline := earliest(conn.readDeadline, conn.handshakeDeadline)
+	if conn.readInterrupts > 0 {
+		readDeadline = pastDeadline
+	}
+	writeDeadline := earliest(conn.writeDeadline, conn.handshakeDeadline)
+	if conn.writeInterrupts > 0 {
+		writeDeadline = pastDeadline
+	}
+	if err = conn.conn.SetReadDeadline(readDeadline); err != nil {
+		return
+	}
+	return conn.conn.SetWriteDeadline(writeDeadline)
+}
+
+// Returns the earliest of two deadlines (the zero time being no
+// deadline)
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// Runs operation, interrupting the directions with a deadline in the
+// past when ctx is done. The interruption holds until operation
+// returns (even if the handshake sets its own deadline meanwhile),
+// and then the previous deadlines are restored.
+func (conn *Connection) withContext(ctx context.Context, directions interruption, operation func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.interrupt(directions, 1)
+		close(interrupted)
+	})
+	err = operation()
+	if !stop() {
+		<-interrupted
+		conn.interrupt(directions, -1)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+	}
+	return
+}
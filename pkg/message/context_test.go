@@ -0,0 +1,154 @@
+package message_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/message"
+)
+
+// Returns two Connections which completed their handshake
+func testConnections(t *testing.T, opts ...message.Option) (client *message.Connection, server *message.Connection) {
+	key := helpers.DeriveKey("")
+	clientConn, serverConn := testConnPair(t)
+	client = message.Client(clientConn, key, opts...)
+	server = message.Server(serverConn, key, opts...)
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("failed to handshake: %v", err)
+	}
+	return
+}
+
+func TestReadMessageContext(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, server := testConnections(t)
+	// Interrupted before the message arrives: the read can be retried
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if data, err := client.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %q (%v)", data, err)
+	}
+	if err := server.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := client.ReadMessageContext(context.Background()); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected message %q (%v)", data, err)
+	}
+	// A context already done
+	if _, err := client.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+	// Interrupted part way through a message: the receiver is broken
+	if _, err := server.NetConn().Write([]byte{0, 0, 0, 0}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.ReadMessageContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, instead got %v", err)
+	}
+	if _, err := client.ReadMessage(); !errors.Is(err, message.ErrConnectionBroken) {
+		t.Fatalf("expected ErrConnectionBroken, instead got %v", err)
+	}
+	// The sender still works
+	go func() { _, _ = server.ReadMessage() }()
+	if err := client.WriteMessage([]byte("still here")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, server := testConnections(t)
+	if err := client.WriteMessageContext(context.Background(), []byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := server.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected message %q (%v)", data, err)
+	}
+	// The server doesn't read a message larger than the socket buffers
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.WriteMessageContext(ctx, make([]byte, 12<<20)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, instead got %v", err)
+	}
+	// The sender is broken
+	for _, err := range []error{client.WriteMessage([]byte("hello")), client.Flush()} {
+		if !errors.Is(err, message.ErrConnectionBroken) {
+			t.Fatalf("expected ErrConnectionBroken, instead got %v", err)
+		}
+	}
+}
+
+func TestHandshakeContextTimeout(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	clientConn, _ := testConnPair(t)
+	// The remote peer never answers
+	client := message.Client(clientConn, key, message.WithHandshakeTimeout(time.Minute))
+	if err := client.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set the read deadline: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if err := client.HandshakeContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the handshake to be interrupted after 20ms, instead took %v", elapsed)
+	}
+	// The deadline set by the user is restored
+	start = time.Now()
+	if _, err := clientConn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, instead got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out at the deadline, instead took %v", elapsed)
+	}
+}
+
+func TestContextDeadlines(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, _ := testConnections(t)
+	// An interrupted read restores the read deadline set by the user
+	if err := client.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set the read deadline: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+	start := time.Now()
+	if _, err := client.NetConn().Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, instead got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the read to time out at the deadline, instead took %v", elapsed)
+	}
+}
+
+func TestWriteMessageRejected(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	client, server := testConnections(t, message.WithFramer(message.FramerUint16))
+	// A message rejected before being sent doesn't break the sender
+	if err := client.WriteMessage(make([]byte, 1<<16)); !errors.Is(err, message.ErrFrameLength) {
+		t.Fatalf("expected ErrFrameLength, instead got %v", err)
+	}
+	if err := client.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := server.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected message %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,45 @@
+package message
+
+import (
+	"context"
+	"net"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+// Connects to the address on the named network (see net.Dial) and
+// performs the handshake as the `RoleClient` (see `DialContext`)
+func Dial(network string, address string, key crypto.AESKey, opts ...Option) (*Connection, error) {
+	return DialContext(context.Background(), network, address, key, opts...)
+}
+
+// Connects to the address on the named network and performs the
+// handshake as the `RoleClient`, such as with a `Listener`.
+//
+// Connecting and the handshake are interrupted when ctx is done. On
+// error the underlying connection is always closed.
+func DialContext(ctx context.Context, network string, address string, key crypto.AESKey, opts ...Option) (connection *Connection, err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return
+	}
+	connection = Client(conn, key, opts...)
+	if err = connection.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return
+}
+
+// Performs the handshake like `Handshake`, interrupting it when ctx
+// is done: the error then matches ctx.Err().
+//
+// The deadlines of the underlying connection are restored once the
+// handshake is interrupted.
+func (conn *Connection) HandshakeContext(ctx context.Context) (err error) {
+	if conn.handshakeComplete.Load() {
+		return
+	}
+	return conn.withContext(ctx, interruptRead|interruptWrite, conn.Handshake)
+}
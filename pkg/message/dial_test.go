@@ -0,0 +1,103 @@
+package message_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/message"
+)
+
+func TestDial(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	listener, err := message.Listen("tcp", "127.0.0.1:0", key)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		if data, err := server.ReadMessage(); err == nil {
+			_ = server.WriteMessage(data)
+		}
+	}()
+	client, err := message.Dial("tcp", listener.Addr().String(), key)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+	if err = client.WriteMessage([]byte("hello gopher")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	if data, err := client.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected reply %q (%v)", data, err)
+	}
+	// The server is gone
+	_ = listener.Close()
+	if _, err := message.Dial("tcp", listener.Addr().String(), key); err == nil {
+		t.Fatalf("expected an error dialing a closed listener")
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	// A server which never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for _, testCase := range []struct {
+		ctx      func() (context.Context, context.CancelFunc)
+		expected error
+	}{
+		{ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, expected: context.DeadlineExceeded},
+		{ctx: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, expected: context.Canceled},
+	} {
+		ctx, cancel := testCase.ctx()
+		connection, err := message.DialContext(ctx, "tcp", listener.Addr().String(), key)
+		cancel()
+		if connection != nil || !errors.Is(err, testCase.expected) {
+			t.Fatalf("expected %v, instead got %v", testCase.expected, err)
+		}
+		// The connection was closed
+		conn := <-accepted
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.Copy(io.Discard, conn); err != nil {
+			t.Fatalf("expected the connection to be closed, instead got %v", err)
+		}
+		_ = conn.Close()
+	}
+	// A context already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := message.DialContext(ctx, "tcp", listener.Addr().String(), key); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, instead got %v", err)
+	}
+}
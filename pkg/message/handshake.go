@@ -0,0 +1,333 @@
+package message
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+const (
+	// The version of the handshake and message protocol spoken by
+	// this package
+	ProtocolVersion = 1
+	// The size of the key confirmation sent at the end of the
+	// handshake (as in TLS)
+	keyConfirmationSize = 12
+)
+
+var (
+	// Error returned by the handshake when the remote peer has the
+	// same role as the local peer (see `WithRole`), or sent back our
+	// own challenge
+	ErrHandshakeRole = errors.New("handshake: the remote peer has the same role")
+	// Error returned by the handshake when the remote peer proves it
+	// doesn't have the same key, or the handshake was tampered with
+	ErrHandshakeAuth = errors.New("handshake: authentication failed (wrong key or tampered handshake)")
+	// Error returned by the handshake when the remote peer speaks
+	// another protocol, or another version of it
+	ErrProtocolVersion = errors.New("handshake: unsupported protocol version")
+	// Error returned by the handshake when the underlying connection
+	// times out (see `WithHandshakeTimeout`)
+	ErrHandshakeTimeout = errors.New("handshake: timed out")
+)
+
+var (
+	// Starts the hello sent by each peer, followed by the version
+	protocolMagic = []byte("GSCM")
+	// Bind the session keys to their use and direction
+	clientKeyInfo = []byte("GoSymCrypto message client to server key v1")
+	serverKeyInfo = []byte("GoSymCrypto message server to client key v1")
+	lowKeyInfo    = []byte("GoSymCrypto message low to high challenge key v1")
+	highKeyInfo   = []byte("GoSymCrypto message high to low challenge key v1")
+	// Binds the key confirmation to its use
+	keyConfirmationLabel = []byte("GoSymCrypto message key confirmation v1")
+)
+
+// Performs the handshake, if it wasn't already performed, and returns
+// its error.
+//
+// `NewConnection` performs the handshake before returning, while the
+// Connections from `Client` and `Server` perform it on the first call
+// to Handshake, a read, or a write. A failed handshake isn't retried:
+// every later call returns the same error.
+func (conn *Connection) Handshake() (err error) {
+	conn.handshakeLock.Lock()
+	defer conn.handshakeLock.Unlock()
+	if conn.handshakeComplete.Load() || conn.handshakeErr != nil {
+		return conn.handshakeErr
+	}
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+	if conn.handshakeTimeout > 0 {
+		conn.setHandshakeDeadline(time.Now().Add(conn.handshakeTimeout))
+		defer conn.setHandshakeDeadline(time.Time{})
+	}
+	err = conn.handshake(conn.preSharedKey)
+	clear(conn.preSharedKey[:])
+	if err != nil {
+		conn.handshakeErr = handshakeError(err)
+		return conn.handshakeErr
+	}
+	conn.handshakeComplete.Store(true)
+	return
+}
+
+// Performs the handshake unless it is complete
+func (conn *Connection) ensureHandshake() (err error) {
+	if conn.handshakeComplete.Load() {
+		return
+	}
+	return conn.Handshake()
+}
+
+// Performs a challenge-response handshake, encrypted with the
+// pre-shared key, and derives the session key
+func (conn *Connection) handshake(preSharedKey crypto.AESKey) (err error) {
+	// To prevent replay-attacks we perform a handshake where the
+	// two peers exchange a challenge.
+	//
+	// The handshake (from the local prospective) goes as such:
+	// 1. we send a hello (the protocol and its version, in plaintext)
+	//    and a challenge to the *remote* peer
+	// 2. we receive the *remote* hello and challenge
+	// 3. we send a key confirmation, and receive the *remote* one
+	//
+	// For each message sent, we'll prefix the data with
+	// `((challengeLocal * counterLocal) ^ challengeRemote)`
+	// and we will increment `counterLocal`.
+	//
+	// For each message received we'll read the prefix and expect it
+	// to match `(challengeLocal ^ (challengeRemote * counterRemote))`
+	// and increment `counterRemote`.
+	//
+	// If the remote message prefix does not match our expected value
+	// we can close the connection and return an error.
+	//
+	// The pre-shared key only encrypts the challenges: the messages
+	// are encrypted with a session key derived (with HKDF) from the
+	// pre-shared key and both challenges, so each connection uses a
+	// different key. A peer with a different pre-shared key fails
+	// to decrypt our challenge and we fail to decrypt theirs.
+	//
+	// The key confirmation is a hash of everything each peer sent
+	// and received, encrypted with the session key: it proves the
+	// remote peer derived the same session key from the same
+	// handshake, so nothing was tampered with.
+	//
+	// With roles (see `WithRole`), each challenge is sent with the
+	// role of its peer, and a challenge from a peer with our own
+	// role (such as our challenge reflected back) is rejected.
+	// Without roles, only our own challenge sent back is rejected.
+	// Each direction uses its own session key, so messages can't be
+	// reflected either.
+
+	// Initialize the counters
+	conn.counterLocal = 0
+	conn.counterRemote = 0
+	// Initialize the local challenge
+	conn.challengeLocal, err = helpers.ReadRandomUint64From(conn.entropy)
+	if err != nil {
+		return
+	}
+	sent, received := newTranscript(), newTranscript()
+	handshakeCipher := helpers.NewAEAD(preSharedKey)
+
+	// Send the hello and the local challenge
+	hello := append(append([]byte(nil), protocolMagic...), ProtocolVersion)
+	var localChallengeBytes []byte
+	if conn.role != RoleSymmetric {
+		localChallengeBytes = append(localChallengeBytes, byte(conn.role))
+	}
+	localChallengeBytes = binary.BigEndian.AppendUint64(localChallengeBytes, conn.challengeLocal)
+	localChallengeFrame, err := helpers.Seal(handshakeCipher, conn.entropy, nil, localChallengeBytes)
+	if err != nil {
+		return
+	}
+	for _, frame := range [][]byte{hello, localChallengeFrame} {
+		if err = conn.encoder.Append(frame); err != nil {
+			return
+		}
+		sent.add(frame)
+	}
+	if err = conn.encoder.Flush(); err != nil {
+		return
+	}
+
+	// Receive the remote hello
+	remoteHello, err := conn.decoder.Decode()
+	if err != nil {
+		return
+	}
+	received.add(remoteHello)
+	if len(remoteHello) != len(hello) || !bytes.HasPrefix(remoteHello, protocolMagic) {
+		err = ErrProtocolVersion
+		return
+	}
+	if version := remoteHello[len(protocolMagic)]; version != ProtocolVersion {
+		err = fmt.Errorf("%w: the remote peer speaks version %d, instead of %d", ErrProtocolVersion, version, ProtocolVersion)
+		return
+	}
+
+	// Receive the remote challenge
+	remoteChallengeFrame, err := conn.decoder.Decode()
+	if err != nil {
+		return
+	}
+	received.add(remoteChallengeFrame)
+	remoteChallengeBytes, err := helpers.Open(handshakeCipher, nil, remoteChallengeFrame)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrHandshakeAuth, err)
+		return
+	}
+	if len(remoteChallengeBytes) != len(localChallengeBytes) {
+		err = ErrInvalidRemoteMessageSize
+		return
+	}
+	if conn.role != RoleSymmetric {
+		if Role(remoteChallengeBytes[0]) != conn.role.remote() {
+			err = ErrHandshakeRole
+			return
+		}
+		remoteChallengeBytes = remoteChallengeBytes[1:]
+	}
+	conn.challengeRemote = binary.BigEndian.Uint64(remoteChallengeBytes)
+	// Our own handshake reflected back
+	if conn.challengeRemote == conn.challengeLocal {
+		err = ErrHandshakeRole
+		return
+	}
+
+	// Derive the session keys
+	sendKey, receiveKey, err := deriveSessionKeys(preSharedKey, conn.role, conn.challengeLocal, conn.challengeRemote)
+	if err != nil {
+		return
+	}
+	conn.sendCipher = helpers.NewAEAD(sendKey)
+	conn.receiveCipher = helpers.NewAEAD(receiveKey)
+	clear(sendKey[:])
+	clear(receiveKey[:])
+
+	// Confirm the keys and the handshake
+	sentHash, receivedHash := sent.sum(), received.sum()
+	if err = conn.encoder.AppendSealed(conn.sendCipher, conn.entropy, keyConfirmation(conn.role, sentHash, receivedHash)); err != nil {
+		return
+	}
+	if err = conn.encoder.Flush(); err != nil {
+		return
+	}
+	remoteConfirmationFrame, err := conn.decoder.Decode()
+	if err != nil {
+		return
+	}
+	remoteConfirmation, err := helpers.Open(conn.receiveCipher, nil, remoteConfirmationFrame)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrHandshakeAuth, err)
+		return
+	}
+	// The remote peer sent what we received and received what we sent
+	if subtle.ConstantTimeCompare(remoteConfirmation, keyConfirmation(conn.role.remote(), receivedHash, sentHash)) != 1 {
+		err = ErrHandshakeAuth
+		return
+	}
+	return
+}
+
+// Returns the key confirmation of a peer with role which sent and
+// received the handshake frames hashed as sent and received
+func keyConfirmation(role Role, sent []byte, received []byte) []byte {
+	confirmation := sha256.New()
+	confirmation.Write(keyConfirmationLabel)
+	confirmation.Write([]byte{byte(role)})
+	confirmation.Write(sent)
+	confirmation.Write(received)
+	return confirmation.Sum(nil)[:keyConfirmationSize]
+}
+
+// A hash of the frames sent (or received) during the handshake
+type transcript struct {
+	hash hash.Hash
+}
+
+// Returns an empty `transcript`
+func newTranscript() *transcript {
+	return &transcript{hash: sha256.New()}
+}
+
+// Adds a frame to the transcript
+func (transcript *transcript) add(frame []byte) {
+	// Length-prefixed, so that the frames can't overlap
+	transcript.hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(frame))))
+	transcript.hash.Write(frame)
+}
+
+// Returns the hash of the frames added
+func (transcript *transcript) sum() []byte {
+	return transcript.hash.Sum(nil)
+}
+
+// Marks timeouts of the underlying connection as handshake timeouts
+func handshakeError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %w", ErrHandshakeTimeout, err)
+	}
+	return err
+}
+
+// Derives the session keys for each direction from the pre-shared
+// key and the two challenges
+func deriveSessionKeys(preSharedKey crypto.AESKey, role Role, challengeLocal uint64, challengeRemote uint64) (sendKey crypto.AESKey, receiveKey crypto.AESKey, err error) {
+	salt := make([]byte, 0, 2*helpers.BytesFor64Bit)
+	switch role {
+	case RoleClient:
+		salt = binary.BigEndian.AppendUint64(salt, challengeLocal)
+		salt = binary.BigEndian.AppendUint64(salt, challengeRemote)
+		if sendKey, err = deriveSessionKey(preSharedKey, salt, clientKeyInfo); err != nil {
+			return
+		}
+		receiveKey, err = deriveSessionKey(preSharedKey, salt, serverKeyInfo)
+	case RoleServer:
+		salt = binary.BigEndian.AppendUint64(salt, challengeRemote)
+		salt = binary.BigEndian.AppendUint64(salt, challengeLocal)
+		if sendKey, err = deriveSessionKey(preSharedKey, salt, serverKeyInfo); err != nil {
+			return
+		}
+		receiveKey, err = deriveSessionKey(preSharedKey, salt, clientKeyInfo)
+	default:
+		// The peers see the challenges in the opposite order, so sort
+		// them; the direction of each key follows from that order
+		salt = binary.BigEndian.AppendUint64(salt, min(challengeLocal, challengeRemote))
+		salt = binary.BigEndian.AppendUint64(salt, max(challengeLocal, challengeRemote))
+		sendInfo, receiveInfo := lowKeyInfo, highKeyInfo
+		if challengeLocal > challengeRemote {
+			sendInfo, receiveInfo = highKeyInfo, lowKeyInfo
+		}
+		if sendKey, err = deriveSessionKey(preSharedKey, salt, sendInfo); err != nil {
+			return
+		}
+		receiveKey, err = deriveSessionKey(preSharedKey, salt, receiveInfo)
+	}
+	return
+}
+
+// Derives a session key with HKDF
+func deriveSessionKey(preSharedKey crypto.AESKey, salt []byte, info []byte) (key crypto.AESKey, err error) {
+	derived, err := helpers.DeriveBytesHKDF(preSharedKey[:], salt, info, helpers.KeySize)
+	if err != nil {
+		return
+	}
+	copy(key[:], derived)
+	clear(derived)
+	return
+}
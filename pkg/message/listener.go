@@ -0,0 +1,251 @@
+package message
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+const (
+	// How long a handshake accepted by a Listener can take, unless
+	// set with `WithHandshakeTimeout`
+	DefaultListenerHandshakeTimeout = 10 * time.Second
+	// How many connections a Listener keeps in their handshake or
+	// waiting for Accept, unless set with `WithMaxPendingHandshakes`
+	DefaultMaxPendingHandshakes = 128
+)
+
+const (
+	// The delays before accepting again after a temporary error of
+	// the underlying listener (as net/http does)
+	minAcceptRetryDelay = 5 * time.Millisecond
+	maxAcceptRetryDelay = time.Second
+)
+
+// Accepts connections and performs their handshakes (as the
+// `RoleServer`), returning the Connections which completed it.
+//
+// The handshakes run concurrently, each with a timeout, so that a
+// slow or malicious client can't block the others. Failed handshakes
+// close their connection and are reported to the handler set with
+// `WithHandshakeErrorHandler`. Once `WithMaxPendingHandshakes`
+// connections are in their handshake or waiting for Accept, no more
+// connections are accepted until one of them is done.
+//
+// Temporary errors of the underlying listener (such as running out
+// of file descriptors) are retried after a delay.
+type Listener struct {
+	// The underlying listener
+	listener net.Listener
+	// The pre-shared key
+	key crypto.AESKey
+	// The options of the Connections
+	opts []Option
+	// Reports the failed handshakes (can be nil)
+	onHandshakeError func(remoteAddr net.Addr, err error)
+	// Holds a value for each connection in its handshake or waiting
+	// for Accept
+	pending chan struct{}
+	// The Connections which completed their handshake
+	accepted chan *Connection
+	// Closed by Close
+	closed chan struct{}
+	// Makes Close idempotent
+	closeOnce *sync.Once
+	// Guards handshaking
+	lock *sync.Mutex
+	// The connections in their handshake (nil once closed)
+	handshaking map[net.Conn]struct{}
+	// Closed when the underlying listener fails
+	failed chan struct{}
+	// The error of the underlying listener
+	err error
+}
+
+// Listens on the network address (see net.Listen) and returns a
+// Listener for it
+func Listen(network string, address string, key crypto.AESKey, opts ...Option) (listener *Listener, err error) {
+	netListener, err := net.Listen(network, address)
+	if err != nil {
+		return
+	}
+	return NewListener(netListener, key, opts...), nil
+}
+
+// Returns a Listener accepting connections from listener, with the
+// given pre-shared key and options
+func NewListener(listener net.Listener, key crypto.AESKey, opts ...Option) *Listener {
+	opts = append([]Option{WithHandshakeTimeout(DefaultListenerHandshakeTimeout)}, opts...)
+	cfg := newConfig(opts)
+	messageListener := &Listener{
+		listener:         listener,
+		key:              key,
+		opts:             opts,
+		onHandshakeError: cfg.onHandshakeError,
+		pending:          make(chan struct{}, cfg.maxPendingHandshakes),
+		accepted:         make(chan *Connection),
+		closed:           make(chan struct{}),
+		closeOnce:        &sync.Once{},
+		lock:             &sync.Mutex{},
+		handshaking:      map[net.Conn]struct{}{},
+		failed:           make(chan struct{}),
+	}
+	go messageListener.acceptLoop()
+	return messageListener
+}
+
+// Waits for and returns the next Connection which completed its
+// handshake.
+//
+// Returns net.ErrClosed after Close, or the error of the underlying
+// listener.
+func (listener *Listener) Accept() (*Connection, error) {
+	select {
+	case connection := <-listener.accepted:
+		return connection, nil
+	case <-listener.failed:
+		return nil, listener.err
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Closes the underlying listener and the connections still in their
+// handshake or not accepted yet
+func (listener *Listener) Close() (err error) {
+	err = net.ErrClosed
+	listener.closeOnce.Do(func() {
+		close(listener.closed)
+		err = listener.listener.Close()
+		listener.lock.Lock()
+		for conn := range listener.handshaking {
+			_ = conn.Close()
+		}
+		listener.handshaking = nil
+		listener.lock.Unlock()
+	})
+	return
+}
+
+// Returns the address of the underlying listener
+func (listener *Listener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
+
+// Accepts the connections and starts their handshakes
+func (listener *Listener) acceptLoop() {
+	var retryDelay time.Duration
+	for {
+		select {
+		case listener.pending <- struct{}{}:
+		case <-listener.closed:
+			return
+		}
+		conn, err := listener.listener.Accept()
+		if err != nil {
+			<-listener.pending
+			select {
+			case <-listener.closed:
+				return
+			default:
+			}
+			if isTemporary(err) {
+				retryDelay = min(max(2*retryDelay, minAcceptRetryDelay), maxAcceptRetryDelay)
+				select {
+				case <-time.After(retryDelay):
+				case <-listener.closed:
+					return
+				}
+				continue
+			}
+			listener.err = err
+			close(listener.failed)
+			return
+		}
+		retryDelay = 0
+		go listener.handshake(conn)
+	}
+}
+
+// Performs the handshake of conn and hands it to Accept
+func (listener *Listener) handshake(conn net.Conn) {
+	defer func() { <-listener.pending }()
+	listener.lock.Lock()
+	if listener.handshaking == nil {
+		// Closed
+		listener.lock.Unlock()
+		_ = conn.Close()
+		return
+	}
+	listener.handshaking[conn] = struct{}{}
+	listener.lock.Unlock()
+	connection := Server(conn, listener.key, listener.opts...)
+	err := connection.Handshake()
+	listener.lock.Lock()
+	delete(listener.handshaking, conn)
+	listener.lock.Unlock()
+	if err != nil {
+		_ = conn.Close()
+		select {
+		case <-listener.closed:
+			// Interrupted by Close
+		default:
+			if listener.onHandshakeError != nil {
+				listener.onHandshakeError(conn.RemoteAddr(), err)
+			}
+		}
+		return
+	}
+	select {
+	case listener.accepted <- connection:
+	case <-listener.closed:
+		_ = conn.Close()
+	}
+}
+
+// Returns whether err is a temporary error of a net.Listener
+func isTemporary(err error) bool {
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// A net.Listener on top of a Listener, returning the Connections
+// which completed their handshake as `Conn`s, so that it can be used
+// by any server working over streams (such as an http.Server)
+type ConnListener struct {
+	// The underlying Listener
+	listener *Listener
+}
+
+// Returns a ConnListener for listener
+func NewConnListener(listener *Listener) *ConnListener {
+	return &ConnListener{listener: listener}
+}
+
+// Waits for and returns the next Connection which completed its
+// handshake, as a `Conn` (see `Listener.Accept`)
+func (listener *ConnListener) Accept() (net.Conn, error) {
+	connection, err := listener.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(connection), nil
+}
+
+// Closes the underlying Listener (see `Listener.Close`)
+func (listener *ConnListener) Close() error {
+	return listener.listener.Close()
+}
+
+// Returns the address of the underlying listener
+func (listener *ConnListener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
+
+// Returns the underlying Listener
+func (listener *ConnListener) Listener() *Listener {
+	return listener.listener
+}
@@ -0,0 +1,164 @@
+package message_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/message"
+)
+
+func TestListener(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	handshakeErrors := make(chan error, 2)
+	listener, err := message.Listen("tcp", "127.0.0.1:0", key,
+		message.WithHandshakeTimeout(100*time.Millisecond),
+		message.WithHandshakeErrorHandler(func(remoteAddr net.Addr, err error) {
+			handshakeErrors <- err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	// A slow client doesn't block the others
+	_ = dial()
+	client := message.Client(dial(), key)
+	go func() { _ = client.WriteMessage([]byte("hello gopher")) }()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	if data, err := server.ReadMessage(); err != nil || string(data) != "hello gopher" {
+		t.Fatalf("unexpected message %q (%v)", data, err)
+	}
+	if err := <-handshakeErrors; !errors.Is(err, message.ErrHandshakeTimeout) {
+		t.Fatalf("expected ErrHandshakeTimeout, instead got %v", err)
+	}
+	// Failed handshakes are reported
+	go func() { _ = message.Client(dial(), helpers.DeriveKey("other")).Handshake() }()
+	if err := <-handshakeErrors; !errors.Is(err, message.ErrHandshakeAuth) {
+		t.Fatalf("expected ErrHandshakeAuth, instead got %v", err)
+	}
+	// Close interrupts Accept
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = listener.Close()
+	}()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, instead got %v", err)
+	}
+	if err := listener.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, instead got %v", err)
+	}
+}
+
+func TestListenerPendingHandshakes(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	handshakeErrors := make(chan error, 1)
+	listener, err := message.Listen("tcp", "127.0.0.1:0", key,
+		message.WithMaxPendingHandshakes(1),
+		message.WithHandshakeTimeout(100*time.Millisecond),
+		message.WithHandshakeErrorHandler(func(remoteAddr net.Addr, err error) {
+			handshakeErrors <- err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	// A slow client holds the only pending handshake until it times out
+	slowConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = slowConn.Close() })
+	time.Sleep(10 * time.Millisecond)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() { _ = message.Client(conn, key).Handshake() }()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	select {
+	case err := <-handshakeErrors:
+		if !errors.Is(err, message.ErrHandshakeTimeout) {
+			t.Fatalf("expected ErrHandshakeTimeout, instead got %v", err)
+		}
+	default:
+		t.Fatalf("expected the second handshake to wait for the first one")
+	}
+}
+
+func TestListenerTemporaryErrors(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	key := helpers.DeriveKey("")
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener := message.NewListener(&flakyListener{Listener: netListener, failures: 3}, key)
+	t.Cleanup(func() { _ = listener.Close() })
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() { _ = message.Client(conn, key).Handshake() }()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatalf("expected the temporary errors to be retried, instead got %v", err)
+	}
+}
+
+// A net.Listener failing its first Accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (listener *flakyListener) Accept() (net.Conn, error) {
+	if listener.failures > 0 {
+		listener.failures--
+		return nil, temporaryError{}
+	}
+	return listener.Listener.Accept()
+}
+
+// A temporary net.Error
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestNewListener(t *testing.T) {
+	t.Parallel() // Can run in parallel
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener := message.NewListener(netListener, helpers.DeriveKey(""))
+	if listener.Addr() != netListener.Addr() {
+		t.Fatalf("expected the address of the underlying listener")
+	}
+	// The underlying listener fails
+	_ = netListener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, instead got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package message
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/stefanovazzocell/GoSymCryto/internal/helpers"
+	"github.com/stefanovazzocell/GoSymCryto/pkg/crypto"
+)
+
+const (
+	// The default maximum size of a frame received by a Connection
+	// (see `WithMaxFrameSize`)
+	DefaultMaxFrameSize = helpers.DefaultMaxFrameSize
+	// The number of bytes a frame adds to a message: the nonce, the
+	// authentication tag and the sequence number (excluding the
+	// length prefix)
+	FrameOverhead = helpers.NonceSize + helpers.TagSize + helpers.BytesFor64Bit
+)
+
+const (
+	// In batching mode, the queued messages are sent once they
+	// reach this many bytes even without a Flush
+	maxBatchSize = 64 << 10
+	// The largest read buffer returned to the pool once a message
+	// was consumed
+	maxRetainedReadBuffer = 64 << 10
+	// The size of the buffer a Connection reads from the underlying
+	// connection through
+	readBufferSize = 4 << 10
+	// How long Close waits for the queued messages to be sent
+	closeFlushTimeout = 5 * time.Second
+)
+
+// An option for `NewConnection`
+type Option func(*config)
+
+// The role of a peer in the handshake (see `WithRole`)
+type Role uint8
+
+const (
+	// Both peers run the same handshake (the default). The direction
+	// of each session key follows from the order of the challenges,
+	// so a peer never accepts its own messages; but nothing tells
+	// the peers apart, so prefer `RoleClient` and `RoleServer`
+	RoleSymmetric Role = iota
+	// The peer which initiated the connection
+	RoleClient
+	// The peer which accepted the connection
+	RoleServer
+)
+
+// Writes and reads the length prefix of frames; one of `FramerUvarint`,
+// `FramerUint16`, `FramerUint32` and `FramerUint64`, or a custom
+// implementation
+type Framer = helpers.Framer
+
+var (
+	// Prefixes frames with their length as an unsigned varint
+	// (1 byte for messages under 92 bytes)
+	FramerUvarint = helpers.FramerUvarint
+	// Prefixes frames with their length as a big-endian uint16
+	// (frames up to 64 KiB)
+	FramerUint16 = helpers.FramerUint16
+	// Prefixes frames with their length as a big-endian uint32
+	// (frames up to 4 GiB)
+	FramerUint32 = helpers.FramerUint32
+	// Prefixes frames with their length as a big-endian uint64
+	// (the default)
+	FramerUint64 = helpers.FramerUint64
+)
+
+// The settings of a Connection
+type config struct {
+	// The source of the challenges and nonces
+	entropy *crypto.Entropy
+	// The maximum size of a received frame
+	maxFrameSize uint64
+	// Writes and reads the length prefix of frames
+	framer Framer
+	// Whether WriteMessage queues messages until Flush
+	batching bool
+	// The role of the local peer in the handshake
+	role Role
+	// How long the handshake can take (0 for no limit)
+	handshakeTimeout time.Duration
+	// Reports the failed handshakes of a Listener (can be nil)
+	onHandshakeError func(remoteAddr net.Addr, err error)
+	// How many connections a Listener keeps in their handshake or
+	// waiting for Accept
+	maxPendingHandshakes int
+}
+
+// Returns the settings with the defaults and the options applied
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		entropy:              crypto.SystemEntropy(),
+		maxFrameSize:         DefaultMaxFrameSize,
+		framer:               FramerUint64,
+		maxPendingHandshakes: DefaultMaxPendingHandshakes,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Reads the handshake challenge and the nonces from entropy instead
+// of the system's random source, which makes the handshake and the
+// messages sent reproducible.
+//
+// NOTE: for tests only; see `crypto.NewTestingEntropy`.
+func WithEntropy(entropy *crypto.Entropy) Option {
+	return func(cfg *config) {
+		cfg.entropy = entropy
+	}
+}
+
+// Sets the maximum size of a frame the Connection accepts (by
+// default `DefaultMaxFrameSize`), which bounds the memory a peer can
+// make it allocate. Larger frames make `ReadMessage` return an error
+// matching `ErrFrameTooLarge` before reading them, and `WriteMessage`
+// before sending them.
+//
+// A frame holds a message plus `FrameOverhead` bytes; both peers
+// should use the same limit, leaving room for the handshake frames
+// (`FrameOverhead` + 4 bytes). A size of 0 restores the default.
+func WithMaxFrameSize(size uint64) Option {
+	return func(cfg *config) {
+		if size == 0 {
+			size = DefaultMaxFrameSize
+		}
+		cfg.maxFrameSize = size
+	}
+}
+
+// Sets the length prefix of frames (by default `FramerUint64`).
+// Both peers must use the same framer. Writing a message too long
+// for the prefix returns an error matching `ErrFrameLength`.
+func WithFramer(framer Framer) Option {
+	return func(cfg *config) {
+		cfg.framer = framer
+	}
+}
+
+// Enables batching mode: `WriteMessage` queues messages instead of
+// sending them, and `Flush` sends all the queued messages with a
+// single write on the underlying connection. Queued messages are
+// also sent once they add up to 64 KiB, and by `Close`.
+//
+// Messages are only received once flushed, so call `Flush` before
+// waiting for a reply.
+func WithBatching() Option {
+	return func(cfg *config) {
+		cfg.batching = true
+	}
+}
+
+// Sets the role of the local peer in the handshake (by default
+// `RoleSymmetric`). One peer must be the `RoleClient` and the other
+// the `RoleServer`: the handshake fails with `ErrHandshakeRole` if
+// both have the same role. Each direction uses its own session key.
+//
+// Without a role, the handshake still rejects a peer reflecting our
+// own handshake back, but it can't check that the remote peer is on
+// the other side of the connection: new code should set a role (or
+// use `Client` and `Server`).
+func WithRole(role Role) Option {
+	return func(cfg *config) {
+		cfg.role = role
+	}
+}
+
+// Sets how long the handshake can take (by default there is no
+// limit, or `DefaultListenerHandshakeTimeout` for a `Listener`),
+// after which it fails with an error matching `ErrHandshakeTimeout`.
+//
+// The deadline is set on the underlying connection for the
+// handshake (unless the one set with `Connection.SetDeadline` is
+// earlier), and then the previous deadlines are restored.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.handshakeTimeout = timeout
+	}
+}
+
+// Sets a function called with the error of each failed handshake
+// of a `Listener`, and the address of the remote peer. It can be
+// called concurrently.
+func WithHandshakeErrorHandler(handler func(remoteAddr net.Addr, err error)) Option {
+	return func(cfg *config) {
+		cfg.onHandshakeError = handler
+	}
+}
+
+// Sets how many connections a `Listener` keeps in their handshake
+// or waiting for Accept (by default `DefaultMaxPendingHandshakes`),
+// which bounds the resources clients can make it hold. A limit of 0
+// or less restores the default.
+func WithMaxPendingHandshakes(limit int) Option {
+	return func(cfg *config) {
+		if limit <= 0 {
+			limit = DefaultMaxPendingHandshakes
+		}
+		cfg.maxPendingHandshakes = limit
+	}
+}
+
+// Returns the name of the role
+func (role Role) String() string {
+	switch role {
+	case RoleSymmetric:
+		return "symmetric"
+	case RoleClient:
+		return "client"
+	case RoleServer:
+		return "server"
+	}
+	return fmt.Sprintf("Role(%d)", uint8(role))
+}
+
+// Returns the role expected from the remote peer
+func (role Role) remote() Role {
+	switch role {
+	case RoleClient:
+		return RoleServer
+	case RoleServer:
+		return RoleClient
+	}
+	return role
+}